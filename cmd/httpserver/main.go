@@ -1,21 +1,37 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
 	httpServer "github.com/portbound/tcp-to-http/internal/server"
 )
 
 const port = 42069
 
 func main() {
-	s, err := httpServer.Serve(port, func(w io.Writer, req *request.Request) *httpServer.HandlerError {
+	root := flag.String("root", "", "serve static files from this directory")
+	listing := flag.Bool("listing", false, "list directories that have no index.html")
+	flag.Parse()
+
+	var files *httpServer.FileServer
+	if *root != "" {
+		var err error
+		files, err = httpServer.NewFileServer(*root)
+		if err != nil {
+			log.Fatalf("Error opening %s: %v", *root, err)
+		}
+		defer files.Close()
+		files.Listing = *listing
+	}
+
+	s, err := httpServer.Serve(port, httpServer.Compress(func(w *response.Writer, req *request.Request) *httpServer.HandlerError {
 		if req.RequestLine.RequestTarget == "/yourproblem" {
 			return &httpServer.HandlerError{StatusCode: 400, Message: "Your problem is not my problem\n"}
 		}
@@ -24,9 +40,13 @@ func main() {
 			return &httpServer.HandlerError{StatusCode: 500, Message: "Whoopsie, my bad\n"}
 		}
 
+		if files != nil {
+			return files.Serve(w, req)
+		}
+
 		fmt.Fprintf(w, "All good, frfr\n")
 		return nil
-	})
+	}))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
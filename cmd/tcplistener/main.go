@@ -37,7 +37,11 @@ func main() {
 			fmt.Printf("- %s: %s\n", key, value)
 		}
 
+		body, err := req.ReadBody()
+		if err != nil {
+			log.Fatalf("error: failed to read body => %s", err)
+		}
 		fmt.Println("Body:")
-		fmt.Println(string(req.Body))
+		fmt.Println(string(body))
 	}
 }
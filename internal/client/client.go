@@ -0,0 +1,343 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+const (
+	defaultDialTimeout    = 10 * time.Second
+	defaultIdleTimeout    = 90 * time.Second
+	defaultMaxIdlePerHost = 2
+	defaultMaxRedirects   = 10
+)
+
+// ErrTooManyRedirects is returned when a request is redirected more than
+// MaxRedirects times.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// Client sends requests over plain TCP and keeps idle connections to each
+// host for reuse. The zero value is ready to use.
+type Client struct {
+	// Timeout bounds a whole exchange on one connection: writing the
+	// request and reading the response. Zero means no limit.
+	Timeout time.Duration
+	// DialTimeout bounds establishing a connection. Zero means 10s.
+	DialTimeout time.Duration
+	// IdleTimeout is how long an unused connection is kept. Zero means 90s.
+	IdleTimeout time.Duration
+	// MaxIdlePerHost is how many idle connections are kept per host. Zero
+	// means 2; negative disables keep-alive.
+	MaxIdlePerHost int
+	// MaxRedirects is how many redirects are followed. Zero means 10;
+	// negative returns redirect responses as they are.
+	MaxRedirects int
+
+	mu   sync.Mutex
+	idle map[string][]*conn
+}
+
+type conn struct {
+	net.Conn
+	br       *bufio.Reader
+	idleFrom time.Time
+	// written counts the bytes of the current request accepted by the
+	// connection.
+	written int
+}
+
+func (cn *conn) Write(p []byte) (int, error) {
+	n, err := cn.Conn.Write(p)
+	cn.written += n
+	return n, err
+}
+
+// NewRequest builds a request for an http URL, with the Host header taken
+// from it.
+func NewRequest(method, rawURL string, body []byte) (*request.Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in %q", rawURL)
+	}
+
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "HTTP/1.1",
+			RequestTarget: u.RequestURI(),
+			Method:        method,
+		},
+		Headers:       headers.Headers{"host": u.Host},
+		Body:          body,
+		ContentLength: len(body),
+	}, nil
+}
+
+func (c *Client) Get(rawURL string) (*response.Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+func (c *Client) Post(rawURL, contentType string, body []byte) (*response.Response, error) {
+	req, err := NewRequest("POST", rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Headers.Set("Content-Type", contentType); err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req to the address in its Host header and returns the response
+// with its body read in full, following redirects.
+func (c *Client) Do(req *request.Request) (*response.Response, error) {
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	for redirects := 0; ; redirects++ {
+		resp, err := c.roundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if maxRedirects < 0 || !isRedirect(resp.StatusLine.StatusCode) || resp.Headers.Get("Location") == "" {
+			return resp, nil
+		}
+		if redirects == maxRedirects {
+			return nil, fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, redirects)
+		}
+
+		req, err = redirectRequest(req, resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// CloseIdleConnections closes every pooled connection.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, cn := range conns {
+			cn.Close()
+		}
+	}
+}
+
+// roundTrip performs one exchange. A pooled connection the server has
+// already closed fails before any response arrives; that case is retried
+// once on a fresh connection. The server may have acted on a request it
+// received before closing, so only idempotent requests, or ones of which
+// nothing was sent, are retried.
+func (c *Client) roundTrip(req *request.Request) (*response.Response, error) {
+	addr := hostAddr(req.Headers.Get("Host"))
+
+	cn, reused, err := c.getConn(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.exchange(cn, req)
+	if err != nil && reused && isStale(err) && (idempotent(req.RequestLine.Method) || cn.written == 0) {
+		cn.Close()
+		if cn, err = c.dial(addr); err != nil {
+			return nil, err
+		}
+		resp, err = c.exchange(cn, req)
+	}
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+
+	method := req.RequestLine.Method
+	if resp.KeepAlive(method) && !strings.EqualFold(req.Headers.Get("Connection"), "close") {
+		c.putConn(addr, cn)
+	} else {
+		cn.Close()
+	}
+	return resp, nil
+}
+
+func (c *Client) exchange(cn *conn, req *request.Request) (*response.Response, error) {
+	if c.Timeout > 0 {
+		cn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	cn.written = 0
+	if err := req.Write(cn); err != nil {
+		return nil, err
+	}
+
+	resp, err := response.ResponseFromReader(cn.br, req.RequestLine.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	cn.SetDeadline(time.Time{})
+	return resp, nil
+}
+
+func (c *Client) getConn(addr string) (*conn, bool, error) {
+	idleTimeout := c.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+
+	c.mu.Lock()
+	for conns := c.idle[addr]; len(conns) > 0; conns = c.idle[addr] {
+		cn := conns[len(conns)-1]
+		c.idle[addr] = conns[:len(conns)-1]
+		if time.Since(cn.idleFrom) < idleTimeout {
+			c.mu.Unlock()
+			return cn, true, nil
+		}
+		cn.Close()
+	}
+	c.mu.Unlock()
+
+	cn, err := c.dial(addr)
+	return cn, false, err
+}
+
+func (c *Client) putConn(addr string, cn *conn) {
+	maxIdle := c.MaxIdlePerHost
+	if maxIdle == 0 {
+		maxIdle = defaultMaxIdlePerHost
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle[addr]) >= maxIdle {
+		cn.Close()
+		return
+	}
+	if c.idle == nil {
+		c.idle = make(map[string][]*conn)
+	}
+	cn.idleFrom = time.Now()
+	c.idle[addr] = append(c.idle[addr], cn)
+}
+
+func (c *Client) dial(addr string) (*conn, error) {
+	dialTimeout := c.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = defaultDialTimeout
+	}
+
+	nc, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: nc, br: bufio.NewReader(nc)}, nil
+}
+
+// isStale reports whether err means the server closed a kept-alive
+// connection before answering.
+func isStale(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// idempotent reports whether sending a request with method twice has the
+// same effect as sending it once (RFC 9110 section 9.2.2).
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func isRedirect(code response.StatusCode) bool {
+	switch code {
+	case response.StatusMovedPermanently, response.StatusFound, response.StatusSeeOther,
+		response.StatusTemporaryRedirect, response.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectRequest builds the request that follows resp's Location. 303,
+// and 301/302 after a POST, switch to a bodiless GET as browsers do; 307
+// and 308 repeat the method and body.
+func redirectRequest(req *request.Request, resp *response.Response) (*request.Request, error) {
+	base, err := url.Parse("http://" + req.Headers.Get("Host") + req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := base.Parse(resp.Headers.Get("Location"))
+	if err != nil {
+		return nil, err
+	}
+
+	method, body := req.RequestLine.Method, req.Body
+	code := resp.StatusLine.StatusCode
+	if code == response.StatusSeeOther || (method == "POST" && (code == response.StatusMovedPermanently || code == response.StatusFound)) {
+		if method != "HEAD" {
+			method = "GET"
+		}
+		body = nil
+	}
+
+	next, err := NewRequest(method, loc.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range req.Headers {
+		switch key {
+		case "host", "content-length", "content-type":
+			continue
+		case "authorization", "cookie":
+			if loc.Host != base.Host {
+				continue
+			}
+		}
+		next.Headers[key] = value
+	}
+	if body != nil {
+		if ct, ok := req.Headers["content-type"]; ok {
+			next.Headers["content-type"] = ct
+		}
+	}
+	return next, nil
+}
+
+// hostAddr turns a Host header value into a dialable address, defaulting
+// to port 80.
+func hostAddr(host string) string {
+	if _, port, err := net.SplitHostPort(host); err == nil && port != "" {
+		return host
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(80))
+}
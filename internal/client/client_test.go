@@ -0,0 +1,288 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+	"github.com/portbound/tcp-to-http/internal/server"
+)
+
+// backend is a keep-alive test server. reply writes the raw response for
+// each request, and conns counts accepted connections.
+type backend struct {
+	listener net.Listener
+	conns    atomic.Int32
+	reply    func(conn net.Conn, req *request.Request) (keepOpen bool)
+}
+
+func newBackend(t *testing.T, reply func(conn net.Conn, req *request.Request) bool) *backend {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	b := &backend{listener: listener, reply: reply}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b.conns.Add(1)
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *backend) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		req, err := request.RequestFromReader(conn)
+		if err != nil {
+			return
+		}
+		if !b.reply(conn, req) {
+			return
+		}
+	}
+}
+
+func (b *backend) url(path string) string {
+	return "http://" + b.listener.Addr().String() + path
+}
+
+func reply(conn net.Conn, status, extra, body string) {
+	fmt.Fprintf(conn, "HTTP/1.1 %s\r\n%sContent-Length: %d\r\n\r\n%s", status, extra, len(body), body)
+}
+
+func TestClientAgainstServer(t *testing.T) {
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		fmt.Fprintf(w, "%s %s", req.RequestLine.Method, req.RequestLine.RequestTarget)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	defer s.Close()
+
+	c := &Client{Timeout: time.Second}
+	resp, err := c.Get("http://" + s.Addr().String() + "/coffee?size=large")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.StatusLine.StatusCode != 200 {
+		t.Errorf("got status %d, want 200", resp.StatusLine.StatusCode)
+	}
+	if got := string(resp.Body); got != "GET /coffee?size=large" {
+		t.Errorf("got body %q, want %q", got, "GET /coffee?size=large")
+	}
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	b := newBackend(t, func(conn net.Conn, req *request.Request) bool {
+		reply(conn, "200 OK", "", string(req.Body))
+		return true
+	})
+
+	c := &Client{Timeout: time.Second}
+	defer c.CloseIdleConnections()
+
+	for i := range 3 {
+		body := fmt.Sprintf("request %d", i)
+		resp, err := c.Post(b.url("/echo"), "text/plain", []byte(body))
+		if err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, err)
+		}
+		if string(resp.Body) != body {
+			t.Errorf("request %d: got body %q, want %q", i, resp.Body, body)
+		}
+	}
+
+	if got := b.conns.Load(); got != 1 {
+		t.Errorf("got %d connections, want 1", got)
+	}
+}
+
+func TestClientRetriesStaleConnection(t *testing.T) {
+	// The backend closes every connection without saying so, which the
+	// client only notices when it reuses one.
+	b := newBackend(t, func(conn net.Conn, req *request.Request) bool {
+		reply(conn, "200 OK", "", "ok")
+		return false
+	})
+
+	c := &Client{Timeout: time.Second}
+	for i := range 2 {
+		if _, err := c.Get(b.url("/")); err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := b.conns.Load(); got != 2 {
+		t.Errorf("got %d connections, want 2", got)
+	}
+}
+
+func TestClientDoesNotReplayPost(t *testing.T) {
+	var posts atomic.Int32
+	b := newBackend(t, func(conn net.Conn, req *request.Request) bool {
+		posts.Add(1)
+		reply(conn, "200 OK", "", "ok")
+		return false
+	})
+
+	c := &Client{Timeout: time.Second}
+	if _, err := c.Post(b.url("/orders"), "text/plain", []byte("one")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// The second POST goes out on the closed connection. The client cannot
+	// tell whether it was processed, so it reports the failure instead of
+	// sending the order again.
+	if _, err := c.Post(b.url("/orders"), "text/plain", []byte("two")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := b.conns.Load(); got != 1 {
+		t.Errorf("got %d connections, want 1", got)
+	}
+	if got := posts.Load(); got != 1 {
+		t.Errorf("got %d orders, want 1", got)
+	}
+}
+
+func TestClientRedirects(t *testing.T) {
+	b := newBackend(t, func(conn net.Conn, req *request.Request) bool {
+		switch req.RequestLine.RequestTarget {
+		case "/old":
+			reply(conn, "301 Moved Permanently", "Location: /new\r\n", "")
+		case "/submit":
+			reply(conn, "303 See Other", "Location: /done\r\n", "")
+		case "/keep":
+			reply(conn, "307 Temporary Redirect", "Location: /done\r\n", "")
+		case "/loop":
+			reply(conn, "302 Found", "Location: /loop\r\n", "")
+		default:
+			reply(conn, "200 OK", "", req.RequestLine.Method+" "+req.RequestLine.RequestTarget+" "+string(req.Body))
+		}
+		return true
+	})
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		maxRedirects int
+		expectError  error
+		expectedBody string
+		expectedCode int
+	}{
+		{name: "Relative location", method: "GET", path: "/old", expectedBody: "GET /new ", expectedCode: 200},
+		{name: "See Other switches to GET", method: "POST", path: "/submit", expectedBody: "GET /done ", expectedCode: 200},
+		{name: "Temporary Redirect keeps method", method: "POST", path: "/keep", expectedBody: "POST /done data", expectedCode: 200},
+		{name: "Redirect loop", method: "GET", path: "/loop", expectError: ErrTooManyRedirects},
+		{name: "Redirects disabled", method: "GET", path: "/old", maxRedirects: -1, expectedCode: 301},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Client{Timeout: time.Second, MaxRedirects: tc.maxRedirects}
+			defer c.CloseIdleConnections()
+
+			var body []byte
+			if tc.method == "POST" {
+				body = []byte("data")
+			}
+			req, err := NewRequest(tc.method, b.url(tc.path), body)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			resp, err := c.Do(req)
+			if tc.expectError != nil {
+				if !errors.Is(err, tc.expectError) {
+					t.Errorf("got error %v, want %v", err, tc.expectError)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if int(resp.StatusLine.StatusCode) != tc.expectedCode {
+				t.Errorf("got status %d, want %d", resp.StatusLine.StatusCode, tc.expectedCode)
+			}
+			if tc.expectedBody != "" && string(resp.Body) != tc.expectedBody {
+				t.Errorf("got body %q, want %q", resp.Body, tc.expectedBody)
+			}
+		})
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	b := newBackend(t, func(conn net.Conn, req *request.Request) bool {
+		time.Sleep(time.Second)
+		return false
+	})
+
+	c := &Client{Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	_, err := c.Get(b.url("/slow"))
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("request took %v, want it cut off near 50ms", elapsed)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	if _, err := NewRequest("GET", "https://example.com/", nil); err == nil || !strings.Contains(err.Error(), "scheme") {
+		t.Errorf("got error %v, want unsupported scheme", err)
+	}
+
+	req, err := NewRequest("GET", "http://example.com:8080/a/b?c=d", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.RequestLine.RequestTarget != "/a/b?c=d" {
+		t.Errorf("got target %q, want %q", req.RequestLine.RequestTarget, "/a/b?c=d")
+	}
+	if got := hostAddr(req.Headers.Get("Host")); got != "example.com:8080" {
+		t.Errorf("got address %q, want %q", got, "example.com:8080")
+	}
+	if got := hostAddr("example.com"); got != "example.com:80" {
+		t.Errorf("got address %q, want %q", got, "example.com:80")
+	}
+}
+
+func TestClientPostRejectsLineBreaks(t *testing.T) {
+	b := newBackend(t, func(conn net.Conn, req *request.Request) bool {
+		reply(conn, "200 OK", "", "ok")
+		return false
+	})
+
+	c := &Client{Timeout: time.Second}
+	_, err := c.Post(b.url("/"), "text/plain\r\nTransfer-Encoding: chunked", []byte("body"))
+	if !errors.Is(err, headers.ErrInvalidValue) {
+		t.Errorf("got %v, want %v", err, headers.ErrInvalidValue)
+	}
+	if got := b.conns.Load(); got != 0 {
+		t.Errorf("got %d connections, want none", got)
+	}
+}
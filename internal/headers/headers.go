@@ -2,14 +2,40 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"strings"
+	"unsafe"
 )
 
 type Headers map[string]string
 
+// ErrInvalidValue is returned for a field value containing CR, LF or NUL,
+// which could otherwise be written out as extra field lines.
+var ErrInvalidValue = errors.New("invalid header field value")
+
+// commonNames interns the lowercased names of frequent fields, so parsing
+// them never allocates a key.
+var commonNames = func() map[string]string {
+	names := []string{
+		"accept", "accept-encoding", "accept-language", "authorization",
+		"cache-control", "connection", "content-encoding", "content-length",
+		"content-type", "cookie", "expect", "host", "if-modified-since",
+		"if-none-match", "origin", "range", "referer", "transfer-encoding",
+		"upgrade", "user-agent",
+	}
+	m := make(map[string]string, len(names))
+	for _, name := range names {
+		m[name] = name
+	}
+	return m
+}()
+
+// maxLowerOnStack is the longest key lowercased without allocating.
+const maxLowerOnStack = 64
+
 func (h Headers) Get(key string) string {
-	val, ok := h[strings.ToLower(key)]
+	val, ok := h.lookup(key)
 	if !ok {
 		if key == "Content-Length" {
 			return "0"
@@ -19,7 +45,112 @@ func (h Headers) Get(key string) string {
 	return val
 }
 
+// Set replaces any value stored under key, matching it case-insensitively.
+// A value containing CR, LF or NUL is refused with ErrInvalidValue and
+// leaves h unchanged.
+func (h Headers) Set(key, value string) error {
+	if !ValidValue(value) {
+		return fmt.Errorf("%w for %s: %q", ErrInvalidValue, key, value)
+	}
+	h[strings.ToLower(key)] = value
+	return nil
+}
+
+// Add appends value to any already stored under key. Repeated fields are
+// combined into one value, which RFC 9110 allows for most of them: with
+// ", " in general and with "; " for Cookie. Set-Cookie values cannot be
+// combined, so they are kept one per line and Values splits them apart;
+// that is why values, which may never contain a line break, are checked as
+// in Set.
+func (h Headers) Add(key, value string) error {
+	if !ValidValue(value) {
+		return fmt.Errorf("%w for %s: %q", ErrInvalidValue, key, value)
+	}
+	key = strings.ToLower(key)
+	if existing, ok := h[key]; ok {
+		h[key] = existing + separator(key) + value
+		return nil
+	}
+	h[key] = value
+	return nil
+}
+
+// ValidValue reports whether value can be sent as a field value: it holds
+// no CR, LF or NUL (RFC 9110 section 5.5).
+func ValidValue(value string) bool {
+	return !strings.ContainsAny(value, "\r\n\x00")
+}
+
+// Values returns the separate values stored under key: one per line for
+// Set-Cookie, and the single combined value for other fields.
+func (h Headers) Values(key string) []string {
+	val, ok := h.lookup(key)
+	if !ok {
+		return nil
+	}
+	if IsMultiLine(key) {
+		return strings.Split(val, "\n")
+	}
+	return []string{val}
+}
+
+// IsMultiLine reports whether values of the field name are written as
+// separate field lines, one per value, instead of a combined value.
+func IsMultiLine(name string) bool {
+	return strings.EqualFold(name, "set-cookie")
+}
+
+// separator is what joins repeated values of the lowercased field name.
+func separator(name string) string {
+	switch name {
+	case "set-cookie":
+		return "\n"
+	case "cookie":
+		return "; "
+	}
+	return ", "
+}
+
+// Del removes key, matching it case-insensitively.
+func (h Headers) Del(key string) {
+	delete(h, strings.ToLower(key))
+}
+
+// lookup finds key case-insensitively. Short keys are lowercased into a
+// stack buffer, which the compiler can index the map with directly.
+func (h Headers) lookup(key string) (string, bool) {
+	if len(key) > maxLowerOnStack {
+		val, ok := h[strings.ToLower(key)]
+		return val, ok
+	}
+	var buf [maxLowerOnStack]byte
+	val, ok := h[string(appendLower(buf[:0], key))]
+	return val, ok
+}
+
+// Parse parses one field line from data, copying the name and value.
+// Repeated fields are combined into one line's worth of value, Set-Cookie
+// included: the one-per-line form only belongs in responses, and a request
+// or part header holding a line break could be forwarded as a field of its
+// own.
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.parse(data, false, false)
+}
+
+// ParseInPlace is like Parse, but the stored value, and the name when it is
+// already lowercase, point into data instead of being copied. The caller must
+// not modify data for as long as h is in use.
+func (h Headers) ParseInPlace(data []byte) (n int, done bool, err error) {
+	return h.parse(data, true, false)
+}
+
+// ParseResponse is like Parse for a response's fields, keeping repeated
+// Set-Cookie values apart for Values to return one by one.
+func (h Headers) ParseResponse(data []byte) (n int, done bool, err error) {
+	return h.parse(data, false, true)
+}
+
+func (h Headers) parse(data []byte, inPlace, response bool) (n int, done bool, err error) {
 	crlf := []byte{'\r', '\n'}
 
 	if bytes.HasPrefix(data, crlf) {
@@ -27,35 +158,93 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	}
 
 	lineEnd := bytes.Index(data, crlf)
+	if lineEnd == -1 {
+		return 0, false, nil
+	}
 	line := data[:lineEnd]
 	line = bytes.TrimSpace(line)
 	colon := bytes.Index(line, []byte{':'})
 
-	if line[colon] <= 0 {
+	if colon <= 0 {
 		return 0, false, fmt.Errorf("invalid header: missing field name")
 	}
 	if line[colon-1] == byte(' ') {
 		return 0, false, fmt.Errorf("invalid header: space before colon")
 	}
 
-	fieldName := string(line[:colon])
-	for _, ch := range fieldName {
-		if !isValidTokenChar(ch) {
+	name := line[:colon]
+	for _, ch := range name {
+		if !isValidTokenChar(rune(ch)) {
 			return 0, false, fmt.Errorf("invalid header: non ascii characters in field-name")
 		}
 	}
 
-	fieldName = strings.ToLower(fieldName)
-	fieldValue := string(bytes.TrimSpace(line[colon+1:]))
+	fieldName := internName(name, inPlace)
+	value := bytes.TrimSpace(line[colon+1:])
+	if bytes.ContainsAny(value, "\r\n\x00") {
+		return 0, false, fmt.Errorf("invalid header: CR, LF or NUL in field-value")
+	}
+
+	var fieldValue string
+	if inPlace {
+		fieldValue = unsafe.String(unsafe.SliceData(value), len(value))
+	} else {
+		fieldValue = string(value)
+	}
 
-	value, ok := h[fieldName]
+	existing, ok := h[fieldName]
 	if ok {
-		h[fieldName] = fmt.Sprintf("%s, %s", value, fieldValue)
+		sep := separator(fieldName)
+		if sep == "\n" && !response {
+			sep = ", "
+		}
+		h[fieldName] = existing + sep + fieldValue
 	} else {
 		h[fieldName] = fieldValue
 	}
 
-	return len(line) + len(crlf), false, nil
+	return lineEnd + len(crlf), false, nil
+}
+
+// internName returns the lowercased field name, preferring an interned
+// string and, in place, one that aliases name when it is already lowercase.
+func internName(name []byte, inPlace bool) string {
+	var buf [maxLowerOnStack]byte
+	if len(name) <= maxLowerOnStack {
+		lower := appendLower(buf[:0], name)
+		if interned, ok := commonNames[string(lower)]; ok {
+			return interned
+		}
+		if inPlace && bytes.Equal(lower, name) {
+			return unsafe.String(unsafe.SliceData(name), len(name))
+		}
+	}
+	return strings.ToLower(string(name))
+}
+
+func appendLower[T string | []byte](dst []byte, s T) []byte {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' {
+			ch += 'a' - 'A'
+		}
+		dst = append(dst, ch)
+	}
+	return dst
+}
+
+// IsToken reports whether s is a non-empty RFC 9110 token, the syntax of
+// field names and of many parameter and cookie names.
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, ch := range s {
+		if !isValidTokenChar(ch) {
+			return false
+		}
+	}
+	return true
 }
 
 func isValidTokenChar(ch rune) bool {
@@ -72,3 +261,21 @@ func isValidTokenChar(ch rune) bool {
 		return false
 	}
 }
+
+// CanonicalKey returns name with the first letter and each letter after a
+// hyphen upper-cased, as fields are conventionally written on the wire
+// ("content-type" becomes "Content-Type").
+func CanonicalKey(name string) string {
+	b := []byte(name)
+	upper := true
+	for i, ch := range b {
+		switch {
+		case upper && 'a' <= ch && ch <= 'z':
+			b[i] = ch - ('a' - 'A')
+		case !upper && 'A' <= ch && ch <= 'Z':
+			b[i] = ch + ('a' - 'A')
+		}
+		upper = ch == '-'
+	}
+	return string(b)
+}
@@ -1,6 +1,9 @@
 package headers
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func NewHeaders() Headers {
 	return make(Headers)
@@ -33,7 +36,7 @@ func TestHeadersParse(t *testing.T) {
 			data:        []byte("       Host: localhost:42069       \r\n\r\n"),
 			expectErr:   false,
 			expectDone:  false,
-			expectBytes: 23,
+			expectBytes: 37,
 			expectVals: map[string]string{
 				"host": "localhost:42069",
 			},
@@ -81,6 +84,14 @@ func TestHeadersParse(t *testing.T) {
 			expectDone:  false,
 			expectBytes: 0,
 		},
+		{
+			name:        "Bare LF in field value",
+			initial:     NewHeaders(),
+			data:        []byte("Set-Cookie: a=1\nX-Injected: yes\r\n\r\n"),
+			expectErr:   true,
+			expectDone:  false,
+			expectBytes: 0,
+		},
 		{
 			name:        "Invalid character in field name",
 			initial:     NewHeaders(),
@@ -117,3 +128,153 @@ func TestHeadersParse(t *testing.T) {
 		})
 	}
 }
+
+func TestHeadersParseInPlace(t *testing.T) {
+	data := []byte("Host: localhost:42069\r\nx-trace-id: abc\r\nX-Custom: Value\r\n\r\n")
+	headers := NewHeaders()
+
+	for pos := 0; ; {
+		n, done, err := headers.ParseInPlace(data[pos:])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pos += n
+		if done {
+			break
+		}
+	}
+
+	expected := map[string]string{
+		"host":       "localhost:42069",
+		"x-trace-id": "abc",
+		"x-custom":   "Value",
+	}
+	for k, want := range expected {
+		if got := headers[k]; got != want {
+			t.Errorf("header %q: got %q, want %q", k, got, want)
+		}
+	}
+
+	// Values alias data, so rewriting it shows through.
+	copy(data[len("Host: "):], "LOCALHOST")
+	if got := headers.Get("Host"); got != "LOCALHOST:42069" {
+		t.Errorf("header %q: got %q, want it to alias the input", "host", got)
+	}
+}
+
+func TestCanonicalKey(t *testing.T) {
+	tests := map[string]string{
+		"content-type":     "Content-Type",
+		"HOST":             "Host",
+		"x-request-id":     "X-Request-Id",
+		"www-authenticate": "Www-Authenticate",
+		"te":               "Te",
+	}
+	for in, want := range tests {
+		if got := CanonicalKey(in); got != want {
+			t.Errorf("CanonicalKey(%q): got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHeadersAdd(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          string
+		values       []string
+		expectValue  string
+		expectValues []string
+	}{
+		{name: "Single", key: "Accept", values: []string{"text/html"}, expectValue: "text/html", expectValues: []string{"text/html"}},
+		{name: "Comma joined", key: "Accept", values: []string{"text/html", "*/*"}, expectValue: "text/html, */*", expectValues: []string{"text/html, */*"}},
+		{name: "Cookie", key: "Cookie", values: []string{"a=1", "b=2"}, expectValue: "a=1; b=2", expectValues: []string{"a=1; b=2"}},
+		{
+			name:         "Set-Cookie kept apart",
+			key:          "Set-Cookie",
+			values:       []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"},
+			expectValue:  "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\nb=2",
+			expectValues: []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHeaders()
+			for _, v := range tc.values {
+				h.Add(tc.key, v)
+			}
+			if got := h.Get(tc.key); got != tc.expectValue {
+				t.Errorf("got %q, want %q", got, tc.expectValue)
+			}
+			got := h.Values(tc.key)
+			if len(got) != len(tc.expectValues) {
+				t.Fatalf("got values %q, want %q", got, tc.expectValues)
+			}
+			for i := range got {
+				if got[i] != tc.expectValues[i] {
+					t.Errorf("value %d: got %q, want %q", i, got[i], tc.expectValues[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHeadersParseRepeated(t *testing.T) {
+	h := NewHeaders()
+	data := []byte("Set-Cookie: a=1\r\nSet-Cookie: b=2\r\nCookie: x=1\r\nCookie: y=2\r\n\r\n")
+	for {
+		n, done, err := h.ParseResponse(data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data = data[n:]
+		if done {
+			break
+		}
+	}
+	if got := h.Values("Set-Cookie"); len(got) != 2 || got[0] != "a=1" || got[1] != "b=2" {
+		t.Errorf("got Set-Cookie values %q", got)
+	}
+	if got := h.Get("Cookie"); got != "x=1; y=2" {
+		t.Errorf("got Cookie %q, want %q", got, "x=1; y=2")
+	}
+}
+
+func TestHeadersRejectLineBreaks(t *testing.T) {
+	h := Headers{"location": "/safe"}
+	for _, value := range []string{"/\r\nSet-Cookie: admin=1", "a\nb", "a\x00b"} {
+		if err := h.Set("Location", value); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("Set %q: got %v, want %v", value, err, ErrInvalidValue)
+		}
+		if err := h.Add("Set-Cookie", value); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("Add %q: got %v, want %v", value, err, ErrInvalidValue)
+		}
+	}
+	if got := h.Get("Location"); got != "/safe" {
+		t.Errorf("got Location %q, want it unchanged", got)
+	}
+	if got := h.Values("Set-Cookie"); got != nil {
+		t.Errorf("got Set-Cookie %q, want none", got)
+	}
+}
+
+func TestHeadersParseJoinsSetCookie(t *testing.T) {
+	h := NewHeaders()
+	data := []byte("Set-Cookie: a\r\nSet-Cookie: Transfer-Encoding: chunked\r\n\r\n")
+	for {
+		n, done, err := h.Parse(data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data = data[n:]
+		if done {
+			break
+		}
+	}
+	if got, want := h.Get("Set-Cookie"), "a, Transfer-Encoding: chunked"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !ValidValue(h.Get("Set-Cookie")) {
+		t.Errorf("got a value with a line break")
+	}
+}
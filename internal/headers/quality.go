@@ -0,0 +1,68 @@
+package headers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// QualityValue is one element of a list such as Accept or Accept-Encoding:
+// the value with any parameters other than the weight, and the weight Q
+// between 0 and 1.
+type QualityValue struct {
+	Value string
+	Q     float64
+}
+
+// ParseQualityList parses a comma-separated list of values with optional
+// ";q=" weights, such as "gzip;q=0.8, br, *;q=0". Values without a weight
+// get 1. Elements with a malformed weight are dropped. The list keeps the
+// order it was sent in.
+func ParseQualityList(value string) []QualityValue {
+	var list []QualityValue
+	for elem := range strings.SplitSeq(value, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			continue
+		}
+
+		params := strings.Split(elem, ";")
+		qv := QualityValue{Value: elem, Q: 1}
+		// The weight is the first "q" parameter. Anything after it is an
+		// extension parameter the caller has no use for.
+		for i, param := range params[1:] {
+			name, arg, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			q, ok := parseQ(strings.TrimSpace(arg))
+			if !ok {
+				qv.Value = ""
+				break
+			}
+			qv.Value = strings.TrimSpace(strings.Join(params[:i+1], ";"))
+			qv.Q = q
+			break
+		}
+
+		if qv.Value != "" {
+			list = append(list, qv)
+		}
+	}
+	return list
+}
+
+// parseQ parses a weight, which RFC 9110 limits to 0 through 1 with at most
+// three decimal places.
+func parseQ(s string) (float64, bool) {
+	if s == "" || len(s) > 5 || (s[0] != '0' && s[0] != '1') {
+		return 0, false
+	}
+	if len(s) > 1 && (s[1] != '.' || strings.TrimLeft(s[2:], "0123456789") != "") {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
@@ -0,0 +1,43 @@
+package headers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseQualityList(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []QualityValue
+	}{
+		{name: "Empty", value: "", expected: nil},
+		{name: "No weights", value: "gzip, deflate", expected: []QualityValue{{"gzip", 1}, {"deflate", 1}}},
+		{
+			name:     "Weights",
+			value:    "gzip;q=0.8, br;q=1.0, *;q=0",
+			expected: []QualityValue{{"gzip", 0.8}, {"br", 1}, {"*", 0}},
+		},
+		{name: "Whitespace and case", value: " gzip ; Q=0.5 ,, identity", expected: []QualityValue{{"gzip", 0.5}, {"identity", 1}}},
+		{
+			name:     "Media type parameters kept",
+			value:    "text/html;level=1;q=0.7, text/plain; charset=utf-8",
+			expected: []QualityValue{{"text/html;level=1", 0.7}, {"text/plain; charset=utf-8", 1}},
+		},
+		{name: "Extension after weight dropped", value: "text/html;q=0.5;ext=1", expected: []QualityValue{{"text/html", 0.5}}},
+		{
+			name:     "Malformed weights dropped",
+			value:    "a;q=2, b;q=0.1234, c;q=x, d;q=-1, e;q=0.1e1, f;q=, g;q=0.001",
+			expected: []QualityValue{{"g", 0.001}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ParseQualityList(tc.value)
+			if !slices.Equal(got, tc.expected) {
+				t.Errorf("got %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
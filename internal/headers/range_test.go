@@ -0,0 +1,68 @@
+package headers
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        string
+		size         int64
+		expectErr    error
+		expectRanges []ByteRange
+	}{
+		{name: "First bytes", value: "bytes=0-499", size: 1000, expectRanges: []ByteRange{{0, 500}}},
+		{name: "Open ended", value: "bytes=900-", size: 1000, expectRanges: []ByteRange{{900, 100}}},
+		{name: "Suffix", value: "bytes=-100", size: 1000, expectRanges: []ByteRange{{900, 100}}},
+		{name: "Suffix longer than size", value: "bytes=-5000", size: 1000, expectRanges: []ByteRange{{0, 1000}}},
+		{name: "End clamped", value: "bytes=500-5000", size: 1000, expectRanges: []ByteRange{{500, 500}}},
+		{
+			name:         "Multiple with whitespace",
+			value:        "bytes= 0-9 , 20-29,, -5",
+			size:         100,
+			expectRanges: []ByteRange{{0, 10}, {20, 10}, {95, 5}},
+		},
+		{name: "Unit is case-insensitive", value: "Bytes=1-1", size: 10, expectRanges: []ByteRange{{1, 1}}},
+		{name: "Unsatisfiable ranges dropped", value: "bytes=2000-,0-0", size: 1000, expectRanges: []ByteRange{{0, 1}}},
+		{name: "Start past end", value: "bytes=1000-", size: 1000, expectErr: ErrUnsatisfiableRange},
+		{name: "Zero suffix", value: "bytes=-0", size: 1000, expectErr: ErrUnsatisfiableRange},
+		{name: "Empty representation", value: "bytes=0-", size: 0, expectErr: ErrUnsatisfiableRange},
+		{name: "Other unit", value: "items=0-1", size: 10, expectErr: ErrInvalidRange},
+		{name: "Missing unit", value: "0-1", size: 10, expectErr: ErrInvalidRange},
+		{name: "No ranges", value: "bytes=", size: 10, expectErr: ErrInvalidRange},
+		{name: "Missing dash", value: "bytes=5", size: 10, expectErr: ErrInvalidRange},
+		{name: "End before start", value: "bytes=5-1", size: 10, expectErr: ErrInvalidRange},
+		{name: "Negative start", value: "bytes=--1", size: 10, expectErr: ErrInvalidRange},
+		{name: "Not a number", value: "bytes=a-b", size: 10, expectErr: ErrInvalidRange},
+		{name: "Signed number", value: "bytes=+1-2", size: 10, expectErr: ErrInvalidRange},
+		{name: "Overflow", value: "bytes=0-99999999999999999999", size: 10, expectErr: ErrInvalidRange},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ranges, err := ParseRange(tc.value, tc.size)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("got error %v, want %v", err, tc.expectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(ranges, tc.expectRanges) {
+				t.Errorf("got %v, want %v", ranges, tc.expectRanges)
+			}
+		})
+	}
+}
+
+func TestByteRangeContentRange(t *testing.T) {
+	got := ByteRange{Start: 900, Length: 100}.ContentRange(1000)
+	if want := "bytes 900-999/1000"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
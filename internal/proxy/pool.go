@@ -0,0 +1,273 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/client"
+	"github.com/portbound/tcp-to-http/internal/request"
+)
+
+const (
+	defaultMaxFails            = 3
+	defaultEjectFor            = 30 * time.Second
+	defaultHealthCheckPath     = "/"
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+
+	// hashReplicas is how many points each upstream gets on the consistent
+	// hash ring. More points spread keys more evenly.
+	hashReplicas = 100
+)
+
+// Upstream is one backend address in a Pool along with what the pool knows
+// about its health and load.
+type Upstream struct {
+	Addr string
+
+	active       atomic.Int64
+	failures     atomic.Int64
+	ejectedUntil atomic.Int64
+	unhealthy    atomic.Bool
+}
+
+// Available reports whether the upstream passes its active health checks
+// and is not ejected for recent failures.
+func (u *Upstream) Available() bool {
+	return !u.unhealthy.Load() && time.Now().UnixNano() >= u.ejectedUntil.Load()
+}
+
+// ActiveRequests is the number of requests currently forwarded to u.
+func (u *Upstream) ActiveRequests() int64 {
+	return u.active.Load()
+}
+
+// Balancer picks the upstream for a request. It is given every upstream in
+// the pool and must only return available ones, or nil if there are none.
+type Balancer interface {
+	Pick(upstreams []*Upstream, req *request.Request) *Upstream
+}
+
+// Pool is a set of upstreams behind a Balancer. An upstream that fails
+// MaxFails requests in a row is ejected for EjectFor, and active health
+// checks can take upstreams out of rotation until they recover.
+type Pool struct {
+	// MaxFails is how many consecutive failures eject an upstream. Zero
+	// means 3.
+	MaxFails int
+	// EjectFor is how long an ejected upstream is skipped. Zero means 30s.
+	EjectFor time.Duration
+
+	balancer  Balancer
+	upstreams []*Upstream
+}
+
+// NewPool returns a pool over addrs, given as host:port, that chooses
+// between them with balancer.
+func NewPool(balancer Balancer, addrs ...string) *Pool {
+	p := &Pool{balancer: balancer}
+	for _, addr := range addrs {
+		p.upstreams = append(p.upstreams, &Upstream{Addr: addr})
+	}
+	return p
+}
+
+// Upstreams returns the upstreams in the order they were given to NewPool.
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+func (p *Pool) pick(req *request.Request) *Upstream {
+	return p.balancer.Pick(p.upstreams, req)
+}
+
+// reportSuccess clears u's failure count.
+func (p *Pool) reportSuccess(u *Upstream) {
+	u.failures.Store(0)
+}
+
+// reportFailure counts a failed request and ejects u once it reaches
+// MaxFails in a row.
+func (p *Pool) reportFailure(u *Upstream) {
+	maxFails := p.MaxFails
+	if maxFails == 0 {
+		maxFails = defaultMaxFails
+	}
+	ejectFor := p.EjectFor
+	if ejectFor == 0 {
+		ejectFor = defaultEjectFor
+	}
+
+	if u.failures.Add(1) >= int64(maxFails) {
+		u.failures.Store(0)
+		u.ejectedUntil.Store(time.Now().Add(ejectFor).UnixNano())
+	}
+}
+
+// HealthCheck configures active checks: a GET for Path on every upstream
+// each Interval, where anything but a 2xx or 3xx answer within Timeout
+// marks the upstream unhealthy until a later check passes.
+type HealthCheck struct {
+	// Path defaults to "/".
+	Path string
+	// Interval defaults to 10s.
+	Interval time.Duration
+	// Timeout defaults to 2s.
+	Timeout time.Duration
+}
+
+// StartHealthChecks checks every upstream right away and then on each
+// interval until the returned function is called.
+func (p *Pool) StartHealthChecks(hc HealthCheck) (stop func()) {
+	if hc.Path == "" {
+		hc.Path = defaultHealthCheckPath
+	}
+	if hc.Interval == 0 {
+		hc.Interval = defaultHealthCheckInterval
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = defaultHealthCheckTimeout
+	}
+
+	c := &client.Client{
+		Timeout:        hc.Timeout,
+		DialTimeout:    hc.Timeout,
+		MaxIdlePerHost: -1,
+		MaxRedirects:   -1,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(hc.Interval)
+		defer ticker.Stop()
+		for {
+			p.checkAll(c, hc.Path)
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (p *Pool) checkAll(c *client.Client, path string) {
+	var wg sync.WaitGroup
+	for _, u := range p.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get("http://" + u.Addr + path)
+			healthy := err == nil && resp.StatusLine.StatusCode >= 200 && resp.StatusLine.StatusCode < 400
+			u.unhealthy.Store(!healthy)
+		}()
+	}
+	wg.Wait()
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin cycles through the available upstreams in order.
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Pick(upstreams []*Upstream, req *request.Request) *Upstream {
+	n := uint64(len(upstreams))
+	start := b.next.Add(1) - 1
+	for i := range n {
+		if u := upstreams[(start+i)%n]; u.Available() {
+			return u
+		}
+	}
+	return nil
+}
+
+type leastConnections struct{}
+
+// LeastConnections picks the available upstream with the fewest requests
+// in flight, preferring the earliest listed on a tie.
+func LeastConnections() Balancer {
+	return leastConnections{}
+}
+
+func (leastConnections) Pick(upstreams []*Upstream, req *request.Request) *Upstream {
+	var best *Upstream
+	for _, u := range upstreams {
+		if !u.Available() {
+			continue
+		}
+		if best == nil || u.ActiveRequests() < best.ActiveRequests() {
+			best = u
+		}
+	}
+	return best
+}
+
+type consistentHash struct {
+	header string
+
+	once   sync.Once
+	points []uint64
+	owners map[uint64]*Upstream
+}
+
+// ConsistentHash sends requests with the same value of header to the same
+// upstream. When that upstream is unavailable its keys move to the next
+// one on the ring, and only those keys move. Requests without the header
+// all hash to the same upstream.
+func ConsistentHash(header string) Balancer {
+	return &consistentHash{header: header}
+}
+
+func (b *consistentHash) Pick(upstreams []*Upstream, req *request.Request) *Upstream {
+	b.once.Do(func() { b.build(upstreams) })
+	if len(b.points) == 0 {
+		return nil
+	}
+
+	key := hash64(req.Headers.Get(b.header))
+	start, _ := slices.BinarySearch(b.points, key)
+	for i := range b.points {
+		point := b.points[(start+i)%len(b.points)]
+		if u := b.owners[point]; u.Available() {
+			return u
+		}
+	}
+	return nil
+}
+
+func (b *consistentHash) build(upstreams []*Upstream) {
+	b.owners = make(map[uint64]*Upstream, len(upstreams)*hashReplicas)
+	for _, u := range upstreams {
+		for i := range hashReplicas {
+			point := hash64(u.Addr + "#" + strconv.Itoa(i))
+			b.owners[point] = u
+			b.points = append(b.points, point)
+		}
+	}
+	slices.Sort(b.points)
+}
+
+// hash64 is FNV-1a followed by the MurmurHash3 finalizer, which spreads
+// near-identical inputs such as "addr#1" and "addr#2" across the ring.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+	"github.com/portbound/tcp-to-http/internal/server"
+)
+
+func newRequest(headerValues map[string]string) *request.Request {
+	h := headers.Headers{"host": "example.com"}
+	for k, v := range headerValues {
+		h.Set(k, v)
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{HttpVersion: "HTTP/1.1", RequestTarget: "/", Method: "GET"},
+		Headers:     h,
+		RemoteAddr:  "192.0.2.1:5000",
+	}
+}
+
+func upstreams(addrs ...string) []*Upstream {
+	var us []*Upstream
+	for _, addr := range addrs {
+		us = append(us, &Upstream{Addr: addr})
+	}
+	return us
+}
+
+func TestRoundRobinSkipsUnavailable(t *testing.T) {
+	us := upstreams("a", "b", "c")
+	us[1].unhealthy.Store(true)
+
+	b := RoundRobin()
+	var got []string
+	for range 4 {
+		got = append(got, b.Pick(us, newRequest(nil)).Addr)
+	}
+	if fmt.Sprint(got) != "[a c c a]" {
+		t.Errorf("got picks %v, want [a c c a]", got)
+	}
+
+	for _, u := range us {
+		u.unhealthy.Store(true)
+	}
+	if u := b.Pick(us, newRequest(nil)); u != nil {
+		t.Errorf("got %s with every upstream down, want nil", u.Addr)
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	us := upstreams("a", "b", "c")
+	us[0].active.Store(3)
+	us[1].active.Store(1)
+	us[2].active.Store(1)
+
+	b := LeastConnections()
+	if got := b.Pick(us, newRequest(nil)).Addr; got != "b" {
+		t.Errorf("got %s, want b", got)
+	}
+
+	us[1].ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	if got := b.Pick(us, newRequest(nil)).Addr; got != "c" {
+		t.Errorf("got %s with b ejected, want c", got)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	us := upstreams("10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80")
+	b := ConsistentHash("X-User")
+
+	before := make(map[string]string)
+	for i := range 200 {
+		user := fmt.Sprintf("user-%d", i)
+		req := newRequest(map[string]string{"X-User": user})
+		first := b.Pick(us, req).Addr
+		if again := b.Pick(us, req).Addr; again != first {
+			t.Fatalf("user %s moved from %s to %s", user, first, again)
+		}
+		before[user] = first
+	}
+
+	used := make(map[string]bool)
+	for _, addr := range before {
+		used[addr] = true
+	}
+	if len(used) != len(us) {
+		t.Errorf("keys spread over %d upstreams, want %d", len(used), len(us))
+	}
+
+	us[1].unhealthy.Store(true)
+	for user, addr := range before {
+		got := b.Pick(us, newRequest(map[string]string{"X-User": user})).Addr
+		if addr != us[1].Addr && got != addr {
+			t.Errorf("user %s moved from %s to %s though its upstream is up", user, addr, got)
+		}
+		if got == us[1].Addr {
+			t.Errorf("user %s sent to unavailable %s", user, got)
+		}
+	}
+}
+
+func TestPoolPassiveEjection(t *testing.T) {
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	live := serve(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		fmt.Fprint(w, "live")
+		return nil
+	})
+
+	pool := NewPool(RoundRobin(), deadAddr, live)
+	pool.MaxFails = 2
+	pool.EjectFor = time.Minute
+	p := NewWithPool(pool)
+
+	failures := 0
+	for range 10 {
+		if handlerErr := p.Serve(response.NewWriter(&bytes.Buffer{}), newRequest(nil)); handlerErr != nil {
+			failures++
+		}
+	}
+
+	if failures != 2 {
+		t.Errorf("got %d failed requests, want 2 before ejection", failures)
+	}
+	if pool.Upstreams()[0].Available() {
+		t.Errorf("dead upstream still available")
+	}
+}
+
+func TestPoolHealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+
+	checked := make(chan struct{}, 16)
+	addr := serve(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if req.RequestLine.RequestTarget == "/healthz" {
+			if !healthy.Load() {
+				w.SetStatusCode(response.StatusServiceUnavailable)
+			}
+			defer func() { checked <- struct{}{} }()
+		}
+		return nil
+	})
+
+	pool := NewPool(RoundRobin(), addr)
+	stop := pool.StartHealthChecks(HealthCheck{Path: "/healthz", Interval: 10 * time.Millisecond, Timeout: time.Second})
+	defer stop()
+
+	waitFor := func(want bool) {
+		t.Helper()
+		deadline := time.After(2 * time.Second)
+		for pool.Upstreams()[0].Available() != want {
+			select {
+			case <-checked:
+			case <-deadline:
+				t.Fatalf("upstream never became available=%v", want)
+			}
+		}
+	}
+
+	waitFor(true)
+	healthy.Store(false)
+	waitFor(false)
+	healthy.Store(true)
+	waitFor(true)
+}
+
+func TestPoolAgainstBackends(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+
+	var addrs []string
+	for i := range 3 {
+		name := fmt.Sprintf("backend-%d", i)
+		addrs = append(addrs, serve(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+			mu.Lock()
+			hits[name]++
+			mu.Unlock()
+			fmt.Fprint(w, name)
+			return nil
+		}))
+	}
+
+	p := NewWithPool(NewPool(ConsistentHash("X-Session"), addrs...))
+	for i := range 30 {
+		session := fmt.Sprintf("s%d", i%3)
+		buf := &bytes.Buffer{}
+		w := response.NewWriter(buf)
+		if handlerErr := p.Serve(w, newRequest(map[string]string{"X-Session": session})); handlerErr != nil {
+			t.Fatalf("unexpected error: %v", handlerErr)
+		}
+		w.Close()
+	}
+
+	total := 0
+	for _, n := range hits {
+		if n%10 != 0 {
+			t.Errorf("backend hits %v are not whole sessions", hits)
+			break
+		}
+		total += n
+	}
+	if total != 30 {
+		t.Errorf("got %d hits, want 30", total)
+	}
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+	"github.com/portbound/tcp-to-http/internal/server"
+)
+
+const defaultDialTimeout = 10 * time.Second
+
+// hopHeaders describe a single connection rather than the message, so a
+// proxy must not forward them (RFC 9110 section 7.6.1). Expect is dropped as
+// well because the body is sent upstream without waiting.
+var hopHeaders = []string{
+	"connection",
+	"expect",
+	"keep-alive",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"proxy-connection",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// Proxy is a reverse proxy. Its Serve method is a server.Handler that
+// forwards each request to an upstream chosen by its Pool and streams the
+// response back.
+type Proxy struct {
+	// DialTimeout bounds connecting to an upstream. Zero means 10s.
+	DialTimeout time.Duration
+	// ResponseTimeout bounds sending the request and receiving the
+	// upstream's response head. Zero means no limit. The body is streamed
+	// without a deadline.
+	ResponseTimeout time.Duration
+
+	pool *Pool
+}
+
+// New returns a Proxy that spreads requests across upstreams, given as
+// host:port addresses, round-robin.
+func New(upstreams ...string) *Proxy {
+	return NewWithPool(NewPool(RoundRobin(), upstreams...))
+}
+
+// NewWithPool returns a Proxy that forwards to the upstreams in pool.
+func NewWithPool(pool *Pool) *Proxy {
+	return &Proxy{pool: pool}
+}
+
+func (p *Proxy) Serve(w *response.Writer, req *request.Request) *server.HandlerError {
+	upstream := p.pool.pick(req)
+	if upstream == nil {
+		return &server.HandlerError{
+			StatusCode: response.StatusServiceUnavailable,
+			Message:    "no upstream available",
+		}
+	}
+
+	upstream.active.Add(1)
+	defer upstream.active.Add(-1)
+
+	handlerErr := p.forward(w, req, upstream.Addr)
+	if handlerErr != nil {
+		p.pool.reportFailure(upstream)
+	} else {
+		p.pool.reportSuccess(upstream)
+	}
+	return handlerErr
+}
+
+// forward sends req to addr and relays the upstream response to w. It
+// returns an error only for failures of the upstream, which count against
+// it. One that cuts the body off after the response has started makes the
+// server drop the connection rather than finish the response, so the client
+// cannot mistake what it got for the whole body. A client that goes away is
+// only logged.
+func (p *Proxy) forward(w *response.Writer, req *request.Request, addr string) *server.HandlerError {
+	dialTimeout := p.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = defaultDialTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return upstreamError(addr, err)
+	}
+	defer conn.Close()
+
+	if p.ResponseTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.ResponseTimeout))
+	}
+
+	if err := outgoingRequest(req).WriteHead(conn); err != nil {
+		return upstreamError(addr, err)
+	}
+	if _, err := io.Copy(conn, req.BodyReader()); err != nil {
+		return upstreamError(addr, err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := readFinalResponseHead(br)
+	if err != nil {
+		return upstreamError(addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	w.SetStatusCode(resp.StatusLine.StatusCode)
+	clear(w.Headers())
+	maps.Copy(w.Headers(), resp.Headers)
+	removeHopHeaders(w.Headers())
+	w.Headers().Set("Connection", "close")
+
+	if err := w.Flush(); err != nil {
+		log.Printf("proxy: writing response head: %v", err)
+		return nil
+	}
+
+	body := &upstreamBody{r: resp.BodyReader(br, req.RequestLine.Method)}
+	if _, err := io.Copy(w, body); err != nil {
+		if body.err != nil {
+			return upstreamError(addr, err)
+		}
+		log.Printf("proxy: streaming response from %s: %v", addr, err)
+	}
+	return nil
+}
+
+// upstreamBody records a failure reading the upstream's body, telling it
+// apart from one writing to the client.
+type upstreamBody struct {
+	r   io.Reader
+	err error
+}
+
+func (b *upstreamBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// outgoingRequest copies req for the upstream, without hop-by-hop fields
+// and with the client recorded in X-Forwarded-For and Forwarded. The
+// original Host is kept so upstreams can route on it.
+func outgoingRequest(req *request.Request) *request.Request {
+	out := &request.Request{
+		RequestLine:   req.RequestLine,
+		Headers:       maps.Clone(req.Headers),
+		ContentLength: req.ContentLength,
+	}
+	removeHopHeaders(out.Headers)
+	out.Headers.Set("Connection", "close")
+
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	if clientIP == "" {
+		return out
+	}
+
+	appendField(out.Headers, "X-Forwarded-For", clientIP)
+
+	node := clientIP
+	if strings.Contains(node, ":") {
+		node = `"[` + node + `]"`
+	}
+	forwarded := "for=" + node + ";proto=http"
+	if host := req.Headers.Get("Host"); host != "" {
+		forwarded += fmt.Sprintf(";host=%q", host)
+	}
+	appendField(out.Headers, "Forwarded", forwarded)
+
+	return out
+}
+
+// removeHopHeaders deletes the standard hop-by-hop fields and any others
+// named in the Connection header.
+func removeHopHeaders(h headers.Headers) {
+	for _, name := range strings.Split(h.Get("Connection"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.Del(name)
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+func appendField(h headers.Headers, key, value string) {
+	if existing := h.Get(key); existing != "" {
+		value = existing + ", " + value
+	}
+	h.Set(key, value)
+}
+
+// readFinalResponseHead skips interim 1xx responses other than 101.
+func readFinalResponseHead(br *bufio.Reader) (*response.Response, error) {
+	for {
+		resp, err := response.ReadResponseHead(br)
+		if err != nil {
+			return nil, err
+		}
+		code := resp.StatusLine.StatusCode
+		if code < 100 || code >= 200 || code == response.StatusSwitchingProtocols {
+			return resp, nil
+		}
+	}
+}
+
+// upstreamError maps a failure talking to an upstream to 504 when it timed
+// out and 502 otherwise.
+func upstreamError(addr string, err error) *server.HandlerError {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &server.HandlerError{
+			StatusCode: response.StatusGatewayTimeout,
+			Message:    fmt.Sprintf("upstream %s timed out", addr),
+		}
+	}
+	return &server.HandlerError{
+		StatusCode: response.StatusBadGateway,
+		Message:    fmt.Sprintf("upstream %s: %v", addr, err),
+	}
+}
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/client"
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+	"github.com/portbound/tcp-to-http/internal/server"
+)
+
+// serve starts a server.Serve instance on an ephemeral port and returns its
+// loopback address.
+func serve(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	if err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+}
+
+// echo answers with the request it received, one header per line.
+func echo(w *response.Writer, req *request.Request) *server.HandlerError {
+	body, err := req.ReadBody()
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusBadRequest, Message: err.Error()}
+	}
+	w.Headers().Set("X-Upstream", "echo")
+	fmt.Fprintf(w, "%s %s\n", req.RequestLine.Method, req.RequestLine.RequestTarget)
+	for _, key := range []string{"host", "x-forwarded-for", "forwarded", "connection", "keep-alive", "x-secret"} {
+		fmt.Fprintf(w, "%s=%s\n", key, req.Headers[key])
+	}
+	fmt.Fprintf(w, "body=%s", body)
+	return nil
+}
+
+func TestProxyForwards(t *testing.T) {
+	upstream := serve(t, echo)
+	proxyAddr := serve(t, New(upstream).Serve)
+
+	req, err := client.NewRequest("POST", "http://"+proxyAddr+"/submit?x=1", []byte("payload"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.Headers.Set("Connection", "X-Secret")
+	req.Headers.Set("X-Secret", "hop")
+	req.Headers.Set("Keep-Alive", "timeout=5")
+	req.Headers.Set("X-Forwarded-For", "203.0.113.7")
+
+	resp, err := (&client.Client{Timeout: time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "POST /submit?x=1\n" +
+		"host=" + proxyAddr + "\n" +
+		"x-forwarded-for=203.0.113.7, 127.0.0.1\n" +
+		`forwarded=for=127.0.0.1;proto=http;host="` + proxyAddr + `"` + "\n" +
+		"connection=close\n" +
+		"keep-alive=\n" +
+		"x-secret=\n" +
+		"body=payload"
+	if string(resp.Body) != want {
+		t.Errorf("got body\n%s\nwant\n%s", resp.Body, want)
+	}
+	if got := resp.Headers.Get("X-Upstream"); got != "echo" {
+		t.Errorf("got X-Upstream %q, want %q", got, "echo")
+	}
+}
+
+func TestProxyStreamsChunkedResponse(t *testing.T) {
+	upstream := serve(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		for i := range 3 {
+			fmt.Fprintf(w, "part %d\n", i)
+			w.Flush()
+		}
+		return nil
+	})
+	proxyAddr := serve(t, New(upstream).Serve)
+
+	resp, err := (&client.Client{Timeout: time.Second}).Get("http://" + proxyAddr + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := resp.Headers.Get("Transfer-Encoding"); got != "chunked" {
+		t.Errorf("got Transfer-Encoding %q, want chunked", got)
+	}
+	if want := "part 0\npart 1\npart 2\n"; string(resp.Body) != want {
+		t.Errorf("got body %q, want %q", resp.Body, want)
+	}
+}
+
+func TestProxyRoundRobin(t *testing.T) {
+	a := serve(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		fmt.Fprint(w, "a")
+		return nil
+	})
+	b := serve(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		fmt.Fprint(w, "b")
+		return nil
+	})
+	proxyAddr := serve(t, New(a, b).Serve)
+
+	c := &client.Client{Timeout: time.Second}
+	var got strings.Builder
+	for range 4 {
+		resp, err := c.Get("http://" + proxyAddr + "/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got.Write(resp.Body)
+	}
+	if got.String() != "abab" {
+		t.Errorf("got upstream order %q, want %q", got.String(), "abab")
+	}
+}
+
+func TestProxyUpstreamFailures(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	deadAddr := closed.Addr().String()
+	closed.Close()
+
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name         string
+		proxy        *Proxy
+		expectStatus response.StatusCode
+	}{
+		{name: "Connection refused", proxy: New(deadAddr), expectStatus: response.StatusBadGateway},
+		{name: "No upstreams", proxy: New(), expectStatus: response.StatusServiceUnavailable},
+		{name: "Upstream too slow", proxy: &Proxy{pool: NewPool(RoundRobin(), silent.Addr().String()), ResponseTimeout: 50 * time.Millisecond}, expectStatus: response.StatusGatewayTimeout},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &request.Request{
+				RequestLine: request.RequestLine{HttpVersion: "HTTP/1.1", RequestTarget: "/", Method: "GET"},
+				Headers:     headers.Headers{"host": "example.com"},
+				RemoteAddr:  "192.0.2.1:5000",
+			}
+			handlerErr := tc.proxy.Serve(response.NewWriter(&bytes.Buffer{}), req)
+			if handlerErr == nil || handlerErr.StatusCode != tc.expectStatus {
+				t.Errorf("got error %v, want status %d", handlerErr, tc.expectStatus)
+			}
+		})
+	}
+}
+
+func TestProxyDropsCutOffResponse(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4096)
+		conn.Read(buf)
+		fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\npart ")
+	}()
+	proxyAddr := serve(t, New(upstream.Addr().String()).Serve)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(string(raw), "part ") {
+		t.Errorf("got %q, want the part of the body that arrived", raw)
+	}
+	if strings.HasSuffix(string(raw), "0\r\n\r\n") {
+		t.Errorf("got %q, want no final chunk", raw)
+	}
+}
@@ -0,0 +1,69 @@
+package request
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+)
+
+// ErrNoCookie is returned by Cookie when the request carries no cookie with
+// the given name.
+var ErrNoCookie = errors.New("named cookie not present")
+
+// Cookie is a name/value pair sent in a Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// Cookies parses the Cookie header into its name/value pairs, in the order
+// sent. Parsing is lenient, as RFC 6265 asks of servers: pairs with an
+// invalid name or value are skipped rather than failing the whole header,
+// and a value wrapped in double quotes is unquoted.
+func (r *Request) Cookies() []Cookie {
+	header := r.Headers.Get("Cookie")
+	if header == "" {
+		return nil
+	}
+
+	var cookies []Cookie
+	for pair := range strings.SplitSeq(header, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !headers.IsToken(name) {
+			continue
+		}
+		value, ok = parseCookieValue(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// Cookie returns the first cookie named name, or ErrNoCookie.
+func (r *Request) Cookie(name string) (Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return Cookie{}, ErrNoCookie
+}
+
+// parseCookieValue strips optional surrounding quotes and checks the rest
+// holds only cookie-octets. Spaces and commas, which browsers send for
+// values they were given quoted, are let through.
+func parseCookieValue(value string) (string, bool) {
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		if ch < 0x20 || ch >= 0x7f || ch == '"' || ch == ';' || ch == '\\' {
+			return "", false
+		}
+	}
+	return value, true
+}
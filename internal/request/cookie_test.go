@@ -0,0 +1,77 @@
+package request
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCookies(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+		expect  []Cookie
+	}{
+		{name: "None", headers: "", expect: nil},
+		{
+			name:    "Several",
+			headers: "Cookie: session=abc123; theme=dark; lang=en-GB\r\n",
+			expect:  []Cookie{{"session", "abc123"}, {"theme", "dark"}, {"lang", "en-GB"}},
+		},
+		{
+			name:    "Quoted and empty",
+			headers: "Cookie: q=\"a b,c\"; empty=\r\n",
+			expect:  []Cookie{{"q", "a b,c"}, {"empty", ""}},
+		},
+		{
+			name:    "Repeated header lines",
+			headers: "Cookie: a=1\r\nCookie: b=2\r\n",
+			expect:  []Cookie{{"a", "1"}, {"b", "2"}},
+		},
+		{
+			name:    "Invalid pairs skipped",
+			headers: "Cookie: novalue; bad name=1; ok=1; bad=\"x\\\"; ;last=2\r\n",
+			expect:  []Cookie{{"ok", "1"}, {"last", "2"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			input := "GET / HTTP/1.1\r\nHost: localhost\r\n" + tc.headers + "\r\n"
+			r, err := RequestFromReader(&chunkReader{data: input, numBytesPerRead: 7})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer r.Release()
+
+			got := r.Cookies()
+			if len(got) != len(tc.expect) {
+				t.Fatalf("got %q, want %q", got, tc.expect)
+			}
+			for i := range got {
+				if got[i] != tc.expect[i] {
+					t.Errorf("cookie %d: got %q, want %q", i, got[i], tc.expect[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCookie(t *testing.T) {
+	input := "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: id=1; id=2; other=x\r\n\r\n"
+	r, err := RequestFromReader(&chunkReader{data: input, numBytesPerRead: len(input)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Release()
+
+	c, err := r.Cookie("id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Value != "1" {
+		t.Errorf("got %q, want first value %q", c.Value, "1")
+	}
+	if _, err := r.Cookie("missing"); !errors.Is(err, ErrNoCookie) {
+		t.Errorf("got error %v, want %v", err, ErrNoCookie)
+	}
+}
@@ -0,0 +1,99 @@
+package request
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedEncoding is returned by DecodeBody for a Content-Encoding it
+// cannot decode. Servers should answer it with 415.
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// ErrBodyTooLarge is returned by DecodeBody when the body, before or after
+// decoding, is larger than the limit. Servers should answer it with 413.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// DecodeBody replaces a gzip or deflate encoded body with the decoded bytes
+// and drops the Content-Encoding header, so handlers see the body as if it
+// had been sent plain. Several codings, applied in the order listed, are
+// undone in reverse. A limit above zero caps the body both as sent and as
+// decoded, so a small upload cannot expand into an unbounded one. A body
+// held back by "Expect: 100-continue" that declares more than the limit is
+// rejected without asking the client to send it.
+//
+// The request no longer matches the bytes received, so WriteExact returns
+// ErrNotReceived afterwards; Write sends the decoded form.
+func (r *Request) DecodeBody(limit int) error {
+	if limit > 0 && r.ContentLength > limit {
+		return fmt.Errorf("%w: %d bytes declared, limit is %d", ErrBodyTooLarge, r.ContentLength, limit)
+	}
+
+	encoding := r.Headers.Get("Content-Encoding")
+	if encoding == "" {
+		return nil
+	}
+
+	var codings []string
+	for coding := range strings.SplitSeq(encoding, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch coding {
+		case "", "identity":
+		case "gzip", "x-gzip", "deflate":
+			codings = append(codings, coding)
+		default:
+			return fmt.Errorf("%w: %q", ErrUnsupportedEncoding, coding)
+		}
+	}
+
+	body := r.BodyReader()
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		if body, err = decoder(codings[i], body); err != nil {
+			return fmt.Errorf("error decoding %s body: %w", codings[i], err)
+		}
+	}
+
+	if limit > 0 {
+		body = io.LimitReader(body, int64(limit)+1)
+	}
+	decoded, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("error decoding body: %w", err)
+	}
+	if limit > 0 && len(decoded) > limit {
+		return fmt.Errorf("%w: decodes to more than %d bytes", ErrBodyTooLarge, limit)
+	}
+
+	r.Body = decoded
+	r.ContentLength = len(decoded)
+	r.Headers.Del("Content-Encoding")
+	r.Headers.Set("Content-Length", strconv.Itoa(len(decoded)))
+	r.raw = nil
+	return nil
+}
+
+func decoder(coding string, body io.Reader) (io.Reader, error) {
+	if coding != "deflate" {
+		return gzip.NewReader(body)
+	}
+
+	// "deflate" is meant to be a zlib stream, but some clients send a bare
+	// flate stream instead. A zlib stream starts with a two byte header
+	// whose method is 8 and whose value is a multiple of 31.
+	br := bufio.NewReader(body)
+	header, err := br.Peek(2)
+	if err != nil && len(header) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
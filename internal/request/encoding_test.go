@@ -0,0 +1,191 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func encode(t *testing.T, coding, data string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	}
+	io.WriteString(w, data)
+	w.Close()
+	return buf.String()
+}
+
+func encodedRequest(encoding, headers, body string) string {
+	return fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n%s\r\n%s",
+		encoding, len(body), headers, body)
+}
+
+func TestDecodeBody(t *testing.T) {
+	payload := strings.Repeat(`{"event":"click","x":1}`, 50)
+
+	tests := []struct {
+		name          string
+		input         string
+		limit         int
+		expectErr     error
+		expectAnyErr  bool
+		expectBody    string
+		expectHeaders map[string]string
+	}{
+		{
+			name:          "gzip",
+			input:         encodedRequest("gzip", "", encode(t, "gzip", payload)),
+			expectBody:    payload,
+			expectHeaders: map[string]string{"Content-Encoding": "", "Content-Length": fmt.Sprint(len(payload))},
+		},
+		{name: "x-gzip", input: encodedRequest("x-gzip", "", encode(t, "gzip", payload)), expectBody: payload},
+		{name: "deflate", input: encodedRequest("deflate", "", encode(t, "deflate", payload)), expectBody: payload},
+		{name: "Raw deflate", input: encodedRequest("deflate", "", encode(t, "raw deflate", payload)), expectBody: payload},
+		{name: "Case-insensitive", input: encodedRequest("GZip", "", encode(t, "gzip", payload)), expectBody: payload},
+		{name: "Identity", input: encodedRequest("identity", "", payload), expectBody: payload},
+		{
+			name:       "Stacked codings",
+			input:      encodedRequest("deflate, gzip", "", encode(t, "gzip", encode(t, "deflate", payload))),
+			expectBody: payload,
+		},
+		{
+			name:       "Not encoded",
+			input:      "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello",
+			expectBody: "hello",
+		},
+		{name: "Within limit", input: encodedRequest("gzip", "", encode(t, "gzip", payload)), limit: len(payload), expectBody: payload},
+		{
+			name:      "Decoded size over limit",
+			input:     encodedRequest("gzip", "", encode(t, "gzip", strings.Repeat("\x00", 1<<20))),
+			limit:     4096,
+			expectErr: ErrBodyTooLarge,
+		},
+		{
+			name:      "Declared size over limit",
+			input:     encodedRequest("identity", "", payload),
+			limit:     10,
+			expectErr: ErrBodyTooLarge,
+		},
+		{name: "Unsupported", input: encodedRequest("br", "", payload), expectErr: ErrUnsupportedEncoding},
+		{name: "Unsupported in list", input: encodedRequest("gzip, compress", "", payload), expectErr: ErrUnsupportedEncoding},
+		{name: "Corrupt", input: encodedRequest("gzip", "", "not gzip at all"), expectAnyErr: true},
+		{name: "Truncated", input: encodedRequest("gzip", "", encode(t, "gzip", payload)[:20]), expectAnyErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(&chunkReader{data: tc.input, numBytesPerRead: 7})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer r.Release()
+
+			err = r.DecodeBody(tc.limit)
+			if tc.expectErr != nil || tc.expectAnyErr {
+				if err == nil || (tc.expectErr != nil && !errors.Is(err, tc.expectErr)) {
+					t.Fatalf("got error %v, want %v", err, tc.expectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(r.Body) != tc.expectBody {
+				t.Errorf("got body %q, want %q", r.Body, tc.expectBody)
+			}
+			if r.ContentLength != len(tc.expectBody) {
+				t.Errorf("got ContentLength %d, want %d", r.ContentLength, len(tc.expectBody))
+			}
+			for key, want := range tc.expectHeaders {
+				if got := r.Headers.Get(key); got != want {
+					t.Errorf("got %s %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeBodyExpectContinue(t *testing.T) {
+	payload := strings.Repeat("abc", 100)
+	body := encode(t, "gzip", payload)
+
+	tests := []struct {
+		name           string
+		limit          int
+		expectErr      error
+		expectContinue bool
+	}{
+		{name: "Accepted", limit: 1000, expectContinue: true},
+		{name: "Rejected before continue", limit: 10, expectErr: ErrBodyTooLarge},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(strings.NewReader(encodedRequest("gzip", "Expect: 100-continue\r\n", body)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer r.Release()
+
+			continued := false
+			r.Continue = func() error {
+				continued = true
+				return nil
+			}
+
+			err = r.DecodeBody(tc.limit)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("got error %v, want %v", err, tc.expectErr)
+			}
+			if continued != tc.expectContinue {
+				t.Errorf("got continued %v, want %v", continued, tc.expectContinue)
+			}
+			if tc.expectErr == nil && string(r.Body) != payload {
+				t.Errorf("got body %q, want %q", r.Body, payload)
+			}
+		})
+	}
+}
+
+func TestDecodeBodyForgetsRawHead(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader(encodedRequest("gzip", "", encode(t, "gzip", "hello"))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Release()
+	if err := r.DecodeBody(0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteExact(&buf); !errors.Is(err, ErrNotReceived) {
+		t.Errorf("got %v, want %v", err, ErrNotReceived)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got %q written, want nothing", buf.String())
+	}
+
+	buf.Reset()
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"
+	if buf.String() != want {
+		t.Errorf("got\n%q\nwant\n%q", buf.String(), want)
+	}
+}
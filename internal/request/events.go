@@ -0,0 +1,8 @@
+package request
+
+// LastEventID returns the ID of the last server-sent event a reconnecting
+// EventSource saw, so a stream can resume after it. It is "" on a first
+// connection.
+func (r *Request) LastEventID() string {
+	return r.Headers.Get("Last-Event-ID")
+}
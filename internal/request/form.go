@@ -0,0 +1,107 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultMaxFormSize is the largest form body ParseForm reads when no limit
+// is given.
+const defaultMaxFormSize = 10 << 20
+
+// ParseForm decodes the query string of the request target and, for POST,
+// PUT and PATCH requests with an application/x-www-form-urlencoded body,
+// the body. It fills PostForm with the body fields and Form with both, body
+// values first. "+" decodes to a space and percent-escapes are undone in
+// names and values alike.
+//
+// limit caps the body in bytes, with zero meaning 10 MiB; a larger body
+// returns ErrBodyTooLarge. A body held back by "Expect: 100-continue" is
+// requested only once its declared size is known to fit. Calling ParseForm
+// again does nothing.
+func (r *Request) ParseForm(limit int) error {
+	if r.Form != nil {
+		return nil
+	}
+
+	query, err := r.parseQuery()
+	if err != nil {
+		return err
+	}
+
+	r.PostForm = url.Values{}
+	if r.hasFormBody() {
+		if limit <= 0 {
+			limit = defaultMaxFormSize
+		}
+		if r.ContentLength > limit {
+			return fmt.Errorf("%w: %d bytes declared, limit is %d", ErrBodyTooLarge, r.ContentLength, limit)
+		}
+
+		// ReadBody keeps the body in r.Body, so handlers further down a
+		// chain can still read it.
+		body, err := r.ReadBody()
+		if err != nil {
+			return err
+		}
+		if r.PostForm, err = url.ParseQuery(string(body)); err != nil {
+			return fmt.Errorf("error parsing form: %w", err)
+		}
+	}
+
+	r.Form = mergeValues(r.PostForm, query)
+	return nil
+}
+
+func (r *Request) parseQuery() (url.Values, error) {
+	_, rawQuery, ok := strings.Cut(r.RequestLine.RequestTarget, "?")
+	if !ok {
+		return url.Values{}, nil
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing query: %w", err)
+	}
+	return query, nil
+}
+
+// mergeValues combines body and query fields, body values first.
+func mergeValues(body, query url.Values) url.Values {
+	merged := make(url.Values, len(body)+len(query))
+	for key, values := range body {
+		merged[key] = append(merged[key], values...)
+	}
+	for key, values := range query {
+		merged[key] = append(merged[key], values...)
+	}
+	return merged
+}
+
+// FormValue returns the first value for key in Form, parsing the form with
+// the default limit if it has not been parsed yet. Errors are ignored; call
+// ParseForm to see them.
+func (r *Request) FormValue(key string) string {
+	if r.Form == nil {
+		r.ParseForm(0)
+	}
+	return r.Form.Get(key)
+}
+
+// PostFormValue is FormValue for fields from the body only.
+func (r *Request) PostFormValue(key string) string {
+	if r.Form == nil {
+		r.ParseForm(0)
+	}
+	return r.PostForm.Get(key)
+}
+
+func (r *Request) hasFormBody() bool {
+	switch r.RequestLine.Method {
+	case "POST", "PUT", "PATCH":
+	default:
+		return false
+	}
+	mediaType, _, _ := strings.Cut(r.Headers.Get("Content-Type"), ";")
+	return strings.EqualFold(strings.TrimSpace(mediaType), "application/x-www-form-urlencoded")
+}
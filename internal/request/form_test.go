@@ -0,0 +1,151 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func formRequest(method, target, contentType, body string) string {
+	return fmt.Sprintf("%s %s HTTP/1.1\r\nHost: localhost\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s",
+		method, target, contentType, len(body), body)
+}
+
+func TestParseForm(t *testing.T) {
+	const urlencoded = "application/x-www-form-urlencoded"
+
+	tests := []struct {
+		name           string
+		input          string
+		limit          int
+		expectErr      error
+		expectAnyErr   bool
+		expectForm     url.Values
+		expectPostForm url.Values
+	}{
+		{
+			name:           "Body",
+			input:          formRequest("POST", "/users", urlencoded, "name=Ada+Lovelace&role=admin&role=ops"),
+			expectForm:     url.Values{"name": {"Ada Lovelace"}, "role": {"admin", "ops"}},
+			expectPostForm: url.Values{"name": {"Ada Lovelace"}, "role": {"admin", "ops"}},
+		},
+		{
+			name:           "Percent escapes",
+			input:          formRequest("POST", "/", urlencoded, "q=a%2Bb%3Dc%26d&%C3%A9t%C3%A9=%E2%9C%93&plus=%2B"),
+			expectForm:     url.Values{"q": {"a+b=c&d"}, "été": {"✓"}, "plus": {"+"}},
+			expectPostForm: url.Values{"q": {"a+b=c&d"}, "été": {"✓"}, "plus": {"+"}},
+		},
+		{
+			name:           "Merged with query, body first",
+			input:          formRequest("POST", "/search?q=from+query&page=2", urlencoded, "q=from+body"),
+			expectForm:     url.Values{"q": {"from body", "from query"}, "page": {"2"}},
+			expectPostForm: url.Values{"q": {"from body"}},
+		},
+		{
+			name:           "Content-Type with parameters",
+			input:          formRequest("PUT", "/", "Application/X-WWW-Form-Urlencoded; charset=utf-8", "a=1"),
+			expectForm:     url.Values{"a": {"1"}},
+			expectPostForm: url.Values{"a": {"1"}},
+		},
+		{
+			name:           "Empty values",
+			input:          formRequest("PATCH", "/", urlencoded, "a=&b&=c"),
+			expectForm:     url.Values{"a": {""}, "b": {""}, "": {"c"}},
+			expectPostForm: url.Values{"a": {""}, "b": {""}, "": {"c"}},
+		},
+		{
+			name:           "Query only for GET",
+			input:          "GET /list?sort=name&dir=asc HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			expectForm:     url.Values{"sort": {"name"}, "dir": {"asc"}},
+			expectPostForm: url.Values{},
+		},
+		{
+			name:           "Other content types ignored",
+			input:          formRequest("POST", "/?a=1", "application/json", `{"a":2}`),
+			expectForm:     url.Values{"a": {"1"}},
+			expectPostForm: url.Values{},
+		},
+		{
+			name:      "Body over limit",
+			input:     formRequest("POST", "/", urlencoded, "a="+strings.Repeat("x", 100)),
+			limit:     50,
+			expectErr: ErrBodyTooLarge,
+		},
+		{name: "Bad escape in body", input: formRequest("POST", "/", urlencoded, "a=%zz"), expectAnyErr: true},
+		{name: "Bad escape in query", input: formRequest("POST", "/?a=%", urlencoded, "b=1"), expectAnyErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(&chunkReader{data: tc.input, numBytesPerRead: 5})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer r.Release()
+
+			err = r.ParseForm(tc.limit)
+			if tc.expectErr != nil || tc.expectAnyErr {
+				if err == nil || (tc.expectErr != nil && !errors.Is(err, tc.expectErr)) {
+					t.Fatalf("got error %v, want %v", err, tc.expectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(r.Form, tc.expectForm) {
+				t.Errorf("got Form %v, want %v", r.Form, tc.expectForm)
+			}
+			if !reflect.DeepEqual(r.PostForm, tc.expectPostForm) {
+				t.Errorf("got PostForm %v, want %v", r.PostForm, tc.expectPostForm)
+			}
+		})
+	}
+}
+
+func TestFormValue(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader(formRequest("POST", "/?id=7", "application/x-www-form-urlencoded", "name=Grace&id=8")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Release()
+
+	if got := r.FormValue("name"); got != "Grace" {
+		t.Errorf("got name %q, want %q", got, "Grace")
+	}
+	if got := r.FormValue("id"); got != "8" {
+		t.Errorf("got id %q, want %q", got, "8")
+	}
+	if got := r.PostFormValue("missing"); got != "" {
+		t.Errorf("got missing %q, want empty", got)
+	}
+}
+
+func TestParseFormKeepsDeferredBody(t *testing.T) {
+	const body = "name=Grace"
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\nContent-Length: 10\r\nExpect: 100-continue\r\n\r\n" + body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Release()
+
+	if err := r.ParseForm(0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := r.PostFormValue("name"); got != "Grace" {
+		t.Errorf("got name %q, want %q", got, "Grace")
+	}
+
+	read, err := r.ReadBody()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(read) != body {
+		t.Errorf("got body %q after ParseForm, want %q", read, body)
+	}
+}
@@ -0,0 +1,60 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// defaultMaxJSONSize is the largest JSON body DecodeJSON reads when no limit
+// is given.
+const defaultMaxJSONSize = 1 << 20
+
+// ErrNotJSON is returned by DecodeJSON for a body whose Content-Type is not
+// JSON. Servers should answer it with 415.
+var ErrNotJSON = errors.New("request body is not JSON")
+
+// DecodeJSON decodes a JSON body into v. The Content-Type must be
+// application/json or a +json type such as application/merge-patch+json.
+// Fields v has no place for are an error, as is anything after the first
+// JSON value, so typos in a request are caught rather than ignored.
+//
+// limit caps the body in bytes, with zero meaning 1 MiB; a larger body
+// returns ErrBodyTooLarge. A body held back by "Expect: 100-continue" is
+// requested only once its declared size is known to fit.
+func (r *Request) DecodeJSON(v any, limit int) error {
+	mediaType, _, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return fmt.Errorf("%w: Content-Type %q", ErrNotJSON, r.Headers.Get("Content-Type"))
+	}
+
+	if limit <= 0 {
+		limit = defaultMaxJSONSize
+	}
+	if r.ContentLength > limit {
+		return fmt.Errorf("%w: %d bytes declared, limit is %d", ErrBodyTooLarge, r.ContentLength, limit)
+	}
+
+	// ReadBody keeps the body in r.Body, so handlers further down a chain
+	// can still read it.
+	body, err := r.ReadBody()
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("error decoding JSON: empty body")
+		}
+		return fmt.Errorf("error decoding JSON: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("error decoding JSON: unexpected data after the value")
+	}
+	return nil
+}
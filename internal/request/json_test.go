@@ -0,0 +1,93 @@
+package request
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type user struct {
+		Name  string   `json:"name"`
+		Roles []string `json:"roles"`
+	}
+
+	tests := []struct {
+		name         string
+		input        string
+		limit        int
+		expected     user
+		expectErr    error
+		expectAnyErr bool
+	}{
+		{
+			name:     "Valid",
+			input:    formRequest("POST", "/", "application/json", `{"name":"Ada","roles":["admin"]}`),
+			expected: user{Name: "Ada", Roles: []string{"admin"}},
+		},
+		{
+			name:     "Structured suffix and charset",
+			input:    formRequest("PATCH", "/", "application/merge-patch+json; charset=utf-8", `{"name":"Ada"}`),
+			expected: user{Name: "Ada"},
+		},
+		{name: "Wrong Content-Type", input: formRequest("POST", "/", "text/plain", `{"name":"Ada"}`), expectErr: ErrNotJSON},
+		{name: "Missing Content-Type", input: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\n{}", expectErr: ErrNotJSON},
+		{name: "Declared too large", input: formRequest("POST", "/", "application/json", `{"name":"`+strings.Repeat("a", 100)+`"}`), limit: 50, expectErr: ErrBodyTooLarge},
+		{name: "Unknown field", input: formRequest("POST", "/", "application/json", `{"name":"Ada","admin":true}`), expectAnyErr: true},
+		{name: "Wrong type", input: formRequest("POST", "/", "application/json", `{"name":7}`), expectAnyErr: true},
+		{name: "Malformed", input: formRequest("POST", "/", "application/json", `{"name":`), expectAnyErr: true},
+		{name: "Trailing data", input: formRequest("POST", "/", "application/json", `{"name":"Ada"} {}`), expectAnyErr: true},
+		{name: "Empty", input: formRequest("POST", "/", "application/json", ""), expectAnyErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(&chunkReader{data: tc.input, numBytesPerRead: 5})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer r.Release()
+
+			var got user
+			err = r.DecodeJSON(&got, tc.limit)
+			if tc.expectErr != nil || tc.expectAnyErr {
+				if err == nil || (tc.expectErr != nil && !errors.Is(err, tc.expectErr)) {
+					t.Fatalf("got error %v, want %v", err, tc.expectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Name != tc.expected.Name || strings.Join(got.Roles, ",") != strings.Join(tc.expected.Roles, ",") {
+				t.Errorf("got %+v, want %+v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONKeepsDeferredBody(t *testing.T) {
+	const body = `{"name":"Grace"}`
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Type: application/json\r\nContent-Length: 16\r\nExpect: 100-continue\r\n\r\n" + body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Release()
+
+	var got struct{ Name string }
+	if err := r.DecodeJSON(&got, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Name != "Grace" {
+		t.Errorf("got name %q, want %q", got.Name, "Grace")
+	}
+
+	read, err := r.ReadBody()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(read) != body {
+		t.Errorf("got body %q after DecodeJSON, want %q", read, body)
+	}
+}
@@ -0,0 +1,498 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+)
+
+const (
+	// defaultMaxMemory is how many bytes of values and file parts
+	// ParseMultipartForm keeps in memory, across all parts, when no limit
+	// is given.
+	defaultMaxMemory = 10 << 20
+
+	// defaultMaxTotalSize is the largest body ParseMultipartForm accepts
+	// when no limit is given, which bounds what can be spilled to disk.
+	defaultMaxTotalSize = 32 << 20
+
+	// maxPartHeaderSize caps the header section of a single part.
+	maxPartHeaderSize = 16 << 10
+
+	// multipartBufferSize is the read buffer a MultipartReader scans for
+	// boundaries. It must be larger than the longest delimiter, which RFC
+	// 2046 limits to 70 characters plus 4 for the leading CRLF and dashes.
+	multipartBufferSize = 4096
+)
+
+// ErrNotMultipart is returned for a request whose body is not
+// multipart/form-data.
+var ErrNotMultipart = errors.New("request is not multipart/form-data")
+
+// ErrPartTooLarge is returned by ParseMultipartForm when one part is larger
+// than MaxPartSize. Like ErrBodyTooLarge, servers should answer it with 413.
+var ErrPartTooLarge = errors.New("multipart part too large")
+
+// MultipartReader reads the parts of a multipart/form-data body as they
+// arrive. Each part must be read, or skipped by calling NextPart, before
+// the next one.
+type MultipartReader struct {
+	br       *bufio.Reader
+	dash     []byte // "--" boundary, which opens the body
+	delim    []byte // CRLF "--" boundary, which ends every part
+	current  *Part
+	started  bool
+	finished bool
+}
+
+// Part is one part of a multipart body. Reading it yields the part's
+// content up to the next boundary.
+type Part struct {
+	Headers headers.Headers
+
+	mr   *MultipartReader
+	done bool
+}
+
+// MultipartReader returns a reader over the parts of the body, taking the
+// boundary from the Content-Type header. RequestFromReader leaves a
+// multipart body unread, so it is streamed from the connection rather than
+// buffered, unless something such as ReadBody has read it already.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 {
+		return nil, fmt.Errorf("%w: invalid boundary %q", ErrNotMultipart, boundary)
+	}
+	return NewMultipartReader(r.BodyReader(), boundary), nil
+}
+
+// NewMultipartReader returns a MultipartReader for a body delimited by
+// boundary.
+func NewMultipartReader(body io.Reader, boundary string) *MultipartReader {
+	return &MultipartReader{
+		br:    bufio.NewReaderSize(body, multipartBufferSize),
+		dash:  []byte("--" + boundary),
+		delim: []byte("\r\n--" + boundary),
+	}
+}
+
+// NextPart skips whatever is left of the current part and returns the
+// next one, or io.EOF after the closing boundary.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.current != nil {
+		if _, err := io.Copy(io.Discard, mr.current); err != nil {
+			return nil, err
+		}
+		mr.current = nil
+	}
+	if mr.finished {
+		return nil, io.EOF
+	}
+
+	if !mr.started {
+		if err := mr.skipPreamble(); err != nil {
+			return nil, err
+		}
+		mr.started = true
+	} else {
+		// The finished part left the reader on its delimiter.
+		if _, err := mr.br.Discard(len(mr.delim)); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		// The closing boundary may end the body without a CRLF.
+		line, err := mr.br.ReadSlice('\n')
+		if bytes.HasPrefix(line, []byte("--")) {
+			mr.finished = true
+			return nil, io.EOF
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, unexpectedEOF(err)
+		}
+		if len(bytes.TrimRight(line, " \t\r\n")) != 0 {
+			return nil, fmt.Errorf("error: unexpected data after multipart boundary")
+		}
+	}
+	if mr.finished {
+		return nil, io.EOF
+	}
+
+	part := &Part{Headers: make(headers.Headers), mr: mr}
+	size := 0
+	for {
+		line, err := mr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		size += len(line)
+		if size > maxPartHeaderSize {
+			return nil, fmt.Errorf("error: multipart part headers exceed %d bytes", maxPartHeaderSize)
+		}
+
+		n, done, err := part.Headers.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("error: malformed multipart part header %q", line)
+		}
+		if done {
+			break
+		}
+	}
+
+	mr.current = part
+	return part, nil
+}
+
+// skipPreamble discards everything before the first boundary line.
+func (mr *MultipartReader) skipPreamble() error {
+	for {
+		line, err := mr.readLine()
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimRight(line, " \t\r\n")
+		if rest, ok := bytes.CutPrefix(line, mr.dash); ok {
+			switch string(rest) {
+			case "":
+				return nil
+			case "--":
+				mr.finished = true
+				return nil
+			}
+		}
+	}
+}
+
+// readLine reads through the next LF. Lines longer than the buffer, which
+// can only be preamble or a malformed header, come back truncated.
+func (mr *MultipartReader) readLine() ([]byte, error) {
+	line, err := mr.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return line, nil
+	}
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return line, nil
+}
+
+// Read reads the part's content. It stops short of anything that could be
+// the start of the delimiter until enough follows to tell.
+func (p *Part) Read(b []byte) (int, error) {
+	if p.done {
+		return 0, io.EOF
+	}
+
+	br := p.mr.br
+	peek, err := br.Peek(br.Size())
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	delim := p.mr.delim
+	for off := 0; ; {
+		j := bytes.Index(peek[off:], delim)
+		if j < 0 {
+			break
+		}
+		i := off + j
+		switch boundaryEnd(peek[i+len(delim):], err == io.EOF) {
+		case boundaryNo:
+			// The delimiter is followed by content, so it is content too.
+			off = i + 1
+			continue
+		case boundaryUnknown:
+			if i == 0 {
+				// A full buffer of padding after the delimiter; treat it
+				// as content rather than waiting forever.
+				off = 1
+				continue
+			}
+		case boundaryYes:
+			if i == 0 {
+				p.done = true
+				return 0, io.EOF
+			}
+		}
+		n := copy(b, peek[:i])
+		br.Discard(n)
+		return n, nil
+	}
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	safe := len(peek) - len(p.mr.delim) + 1
+	n := copy(b, peek[:safe])
+	br.Discard(n)
+	return n, nil
+}
+
+const (
+	boundaryNo = iota
+	boundaryYes
+	boundaryUnknown
+)
+
+// boundaryEnd reports whether the bytes after a delimiter make it a
+// boundary: one is followed by "--" or by optional whitespace and CRLF.
+// While the bytes seen so far could still become either, and more are to
+// come, it is unknown.
+func boundaryEnd(after []byte, eof bool) int {
+	rest := bytes.TrimLeft(after, " \t")
+	if bytes.HasPrefix(after, []byte("--")) || bytes.HasPrefix(rest, []byte("\r\n")) {
+		return boundaryYes
+	}
+	if !eof && (bytes.HasPrefix([]byte("--"), after) || bytes.HasPrefix([]byte("\r\n"), rest)) {
+		return boundaryUnknown
+	}
+	return boundaryNo
+}
+
+// FormName is the name parameter of the part's Content-Disposition.
+func (p *Part) FormName() string {
+	return p.disposition()["name"]
+}
+
+// FileName is the filename parameter of the part's Content-Disposition,
+// reduced to its last path element so it can never name a directory.
+func (p *Part) FileName() string {
+	name := p.disposition()["filename"]
+	if name == "" {
+		return ""
+	}
+	if i := strings.LastIndexAny(name, `/\`); i != -1 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func (p *Part) disposition() map[string]string {
+	disposition, params, err := mime.ParseMediaType(p.Headers.Get("Content-Disposition"))
+	if err != nil || disposition != "form-data" {
+		return nil
+	}
+	return params
+}
+
+// MultipartLimits bounds what ParseMultipartForm accepts.
+type MultipartLimits struct {
+	// MaxMemory is how many bytes of field values and file content are
+	// kept in memory, across all parts. Files past it are written to
+	// temporary files; values past it fail with ErrBodyTooLarge. Zero
+	// means 10 MiB.
+	MaxMemory int64
+	// MaxPartSize caps any single part. Zero means no limit.
+	MaxPartSize int64
+	// MaxTotalSize caps the whole body, and so the temporary files it can
+	// fill. Zero means 32 MiB and a negative value no limit.
+	MaxTotalSize int64
+}
+
+// MultipartForm is a parsed multipart/form-data body.
+type MultipartForm struct {
+	Value url.Values
+	File  map[string][]*FileHeader
+}
+
+// RemoveAll deletes any temporary files holding uploaded content.
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tmpfile != "" {
+				if err := os.Remove(fh.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FileHeader describes an uploaded file. Its content is in memory or, past
+// the memory limit, in a temporary file; Open reads it either way.
+type FileHeader struct {
+	Filename string
+	Headers  headers.Headers
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+// File is the content of an uploaded file.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Open returns the file's content.
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+	return nopCloser{bytes.NewReader(fh.content)}, nil
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// ParseMultipartForm reads a multipart/form-data body into MultipartForm,
+// and its non-file fields into PostForm and, merged with the query, into
+// Form. Values and files share limits.MaxMemory; files beyond it go to
+// temporary files, which MultipartForm.RemoveAll deletes. The server calls
+// it once the handler returns; other callers must call it themselves. A
+// body declaring more than MaxTotalSize is refused before a held back
+// "Expect: 100-continue" body is requested. Calling it again does nothing.
+func (r *Request) ParseMultipartForm(limits MultipartLimits) (err error) {
+	if r.MultipartForm != nil {
+		return nil
+	}
+	total := limits.MaxTotalSize
+	if total == 0 {
+		total = defaultMaxTotalSize
+	}
+	if total > 0 && int64(r.ContentLength) > total {
+		return fmt.Errorf("%w: %d bytes declared, limit is %d", ErrBodyTooLarge, r.ContentLength, total)
+	}
+	memory := limits.MaxMemory
+	if memory == 0 {
+		memory = defaultMaxMemory
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	form := &MultipartForm{Value: url.Values{}, File: map[string][]*FileHeader{}}
+	defer func() {
+		if err != nil {
+			form.RemoveAll()
+		}
+	}()
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		var content io.Reader = part
+		if limits.MaxPartSize > 0 {
+			content = &limitedPart{r: part, remaining: limits.MaxPartSize}
+		}
+
+		filename := part.FileName()
+		if filename == "" {
+			var value bytes.Buffer
+			n, err := io.CopyN(&value, content, memory+1)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if n > memory {
+				return fmt.Errorf("%w: form values exceed the memory limit", ErrBodyTooLarge)
+			}
+			memory -= n
+			form.Value[name] = append(form.Value[name], value.String())
+			continue
+		}
+
+		fh := &FileHeader{Filename: filename, Headers: part.Headers}
+		var buf bytes.Buffer
+		n, err := io.CopyN(&buf, content, memory+1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n > memory {
+			if err := spill(fh, &buf, content); err != nil {
+				return err
+			}
+		} else {
+			fh.content = buf.Bytes()
+			fh.Size = n
+			memory -= n
+		}
+		form.File[name] = append(form.File[name], fh)
+	}
+
+	r.MultipartForm = form
+	r.PostForm = form.Value
+	query, err := r.parseQuery()
+	if err != nil {
+		return err
+	}
+	r.Form = mergeValues(r.PostForm, query)
+	return nil
+}
+
+// spill writes a file that outgrew the memory limit to a temporary file:
+// the part already read into buf followed by the rest of content.
+func spill(fh *FileHeader, buf *bytes.Buffer, content io.Reader) error {
+	f, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return err
+	}
+	fh.tmpfile = f.Name()
+
+	n, err := io.Copy(f, io.MultiReader(buf, content))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fh.tmpfile)
+		return err
+	}
+	fh.Size = n
+	return nil
+}
+
+// limitedPart fails with ErrPartTooLarge once a part runs past its limit,
+// rather than silently truncating it.
+type limitedPart struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedPart) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrPartTooLarge
+	}
+	return n, err
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
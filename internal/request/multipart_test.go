@@ -0,0 +1,339 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testPart struct {
+	name     string
+	filename string
+	content  string
+}
+
+// multipartRequest builds a request whose body holds parts, written with the
+// standard library's encoder.
+func multipartRequest(target string, parts ...testPart) string {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, p := range parts {
+		h := textproto.MIMEHeader{}
+		disposition := fmt.Sprintf(`form-data; name=%q`, p.name)
+		if p.filename != "" {
+			disposition += fmt.Sprintf(`; filename=%q`, p.filename)
+			h.Set("Content-Type", "application/octet-stream")
+		}
+		h.Set("Content-Disposition", disposition)
+		w, _ := mw.CreatePart(h)
+		io.WriteString(w, p.content)
+	}
+	mw.Close()
+
+	return fmt.Sprintf("POST %s HTTP/1.1\r\nHost: localhost\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s",
+		target, mw.FormDataContentType(), body.Len(), body.String())
+}
+
+func TestMultipartReader(t *testing.T) {
+	large := strings.Repeat("0123456789\r\n-", 1000)
+
+	tests := []struct {
+		name        string
+		input       string
+		chunkSize   int
+		expectParts []testPart
+		expectErr   error
+	}{
+		{
+			name:      "Fields and files",
+			input:     multipartRequest("/", testPart{name: "title", content: "hello"}, testPart{name: "doc", filename: "notes.txt", content: "line 1\r\nline 2\r\n"}),
+			chunkSize: 1024,
+			expectParts: []testPart{
+				{name: "title", content: "hello"},
+				{name: "doc", filename: "notes.txt", content: "line 1\r\nline 2\r\n"},
+			},
+		},
+		{
+			name:        "Large part in small reads",
+			input:       multipartRequest("/", testPart{name: "blob", filename: "blob.bin", content: large}, testPart{name: "after", content: "x"}),
+			chunkSize:   3,
+			expectParts: []testPart{{name: "blob", filename: "blob.bin", content: large}, {name: "after", content: "x"}},
+		},
+		{
+			name:        "Empty part",
+			input:       multipartRequest("/", testPart{name: "empty"}),
+			chunkSize:   1024,
+			expectParts: []testPart{{name: "empty"}},
+		},
+		{
+			name:        "Path stripped from filename",
+			input:       multipartRequest("/", testPart{name: "f", filename: `C:\Users\me\..\evil.txt`, content: "x"}),
+			chunkSize:   1024,
+			expectParts: []testPart{{name: "f", filename: "evil.txt", content: "x"}},
+		},
+		{
+			name: "Preamble, padding and epilogue",
+			input: formRequest("POST", "/", "multipart/form-data; boundary=xyz",
+				"preamble\r\n--xyz  \r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nA\r\n--xyz\r\n\r\nB\r\n--xyz--\r\nepilogue"),
+			chunkSize:   1024,
+			expectParts: []testPart{{name: "a", content: "A"}, {content: "B"}},
+		},
+		{
+			name: "Delimiter inside content",
+			input: formRequest("POST", "/", "multipart/form-data; boundary=xyz",
+				"--xyz\r\n\r\nsee\r\n--xyzzy and\r\n--xyz-\r\n--xyz \t\r\n\r\nlast\r\n--xyz--"),
+			chunkSize:   2,
+			expectParts: []testPart{{content: "see\r\n--xyzzy and\r\n--xyz-"}, {content: "last"}},
+		},
+		{
+			name:      "Missing closing boundary",
+			input:     formRequest("POST", "/", "multipart/form-data; boundary=xyz", "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nA"),
+			chunkSize: 1024,
+			expectErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:      "Not multipart",
+			input:     formRequest("POST", "/", "application/x-www-form-urlencoded", "a=1"),
+			chunkSize: 1024,
+			expectErr: ErrNotMultipart,
+		},
+		{
+			name:      "Missing boundary",
+			input:     formRequest("POST", "/", "multipart/form-data", "a=1"),
+			chunkSize: 1024,
+			expectErr: ErrNotMultipart,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(&chunkReader{data: tc.input, numBytesPerRead: tc.chunkSize})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer r.Release()
+
+			parts, err := readParts(r)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("got error %v, want %v", err, tc.expectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(parts) != len(tc.expectParts) {
+				t.Fatalf("got %d parts, want %d", len(parts), len(tc.expectParts))
+			}
+			for i, want := range tc.expectParts {
+				if parts[i] != want {
+					t.Errorf("part %d: got %.60q, want %.60q", i, parts[i], want)
+				}
+			}
+		})
+	}
+}
+
+func readParts(r *Request) ([]testPart, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var parts []testPart
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, testPart{name: part.FormName(), filename: part.FileName(), content: string(content)})
+	}
+}
+
+func TestMultipartReaderSkipsUnreadParts(t *testing.T) {
+	input := multipartRequest("/", testPart{name: "skipped", content: strings.Repeat("s", 10000)}, testPart{name: "read", content: "kept"})
+	r, err := RequestFromReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Release()
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := mr.NextPart(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := part.Headers.Get("Content-Disposition"); got != `form-data; name="read"` {
+		t.Errorf("got Content-Disposition %q", got)
+	}
+	if content, _ := io.ReadAll(part); string(content) != "kept" {
+		t.Errorf("got %q, want %q", content, "kept")
+	}
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	small := "small file"
+	big := strings.Repeat("big file ", 1000)
+	input := multipartRequest("/upload?album=trip",
+		testPart{name: "caption", content: "Day one"},
+		testPart{name: "photos", filename: "a.txt", content: small},
+		testPart{name: "photos", filename: "b.txt", content: big},
+		testPart{name: "album", content: "holiday"},
+	)
+
+	tests := []struct {
+		name        string
+		limits      MultipartLimits
+		expectErr   error
+		expectSpill []bool
+	}{
+		{name: "In memory", limits: MultipartLimits{}, expectSpill: []bool{false, false}},
+		{name: "Spilled past memory limit", limits: MultipartLimits{MaxMemory: 100}, expectSpill: []bool{false, true}},
+		{name: "Memory shared across files", limits: MultipartLimits{MaxMemory: int64(len(big))}, expectSpill: []bool{false, true}},
+		{name: "Part too large", limits: MultipartLimits{MaxMemory: 100, MaxPartSize: 1000}, expectErr: ErrPartTooLarge},
+		{name: "Values count against memory", limits: MultipartLimits{MaxMemory: 5}, expectErr: ErrBodyTooLarge},
+		{name: "Body too large", limits: MultipartLimits{MaxTotalSize: 1000}, expectErr: ErrBodyTooLarge},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+
+			r, err := RequestFromReader(&chunkReader{data: input, numBytesPerRead: 100})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer r.Release()
+
+			err = r.ParseMultipartForm(tc.limits)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("got error %v, want %v", err, tc.expectErr)
+				}
+				if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+					t.Errorf("left %d temporary files behind", len(entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := r.FormValue("caption"); got != "Day one" {
+				t.Errorf("got caption %q, want %q", got, "Day one")
+			}
+			if got := r.Form["album"]; len(got) != 2 || got[0] != "holiday" || got[1] != "trip" {
+				t.Errorf("got album %q, want body value then query value", got)
+			}
+			if got := r.PostForm["album"]; len(got) != 1 {
+				t.Errorf("got PostForm album %q, want body value only", got)
+			}
+
+			files := r.MultipartForm.File["photos"]
+			if len(files) != 2 {
+				t.Fatalf("got %d files, want 2", len(files))
+			}
+			for i, want := range []string{small, big} {
+				fh := files[i]
+				if spilled := fh.tmpfile != ""; spilled != tc.expectSpill[i] {
+					t.Errorf("file %d: got spilled %v, want %v", i, spilled, tc.expectSpill[i])
+				}
+				if fh.Size != int64(len(want)) {
+					t.Errorf("file %d: got size %d, want %d", i, fh.Size, len(want))
+				}
+				f, err := fh.Open()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				content, _ := io.ReadAll(f)
+				f.Close()
+				if string(content) != want {
+					t.Errorf("file %d: got %d bytes, want %d", i, len(content), len(want))
+				}
+			}
+
+			if err := r.MultipartForm.RemoveAll(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+				t.Errorf("RemoveAll left %d temporary files behind", len(entries))
+			}
+		})
+	}
+}
+
+func TestMultipartStreamsFromConnection(t *testing.T) {
+	// The body is read only as parts are consumed: after the first part,
+	// the second is still waiting on the connection.
+	input := multipartRequest("/", testPart{name: "first", content: "1"}, testPart{name: "second", content: strings.Repeat("2", 10000)})
+	src := &countingReader{r: strings.NewReader(input)}
+	r, err := RequestFromReader(src)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Release()
+	if len(r.Body) != 0 {
+		t.Fatalf("got %d body bytes buffered, want none", len(r.Body))
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content, _ := io.ReadAll(part); string(content) != "1" {
+		t.Errorf("got %q, want %q", content, "1")
+	}
+	if src.n >= len(input) {
+		t.Errorf("read all %d bytes before the second part was asked for", src.n)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestParseMultipartFormDefaultLimit(t *testing.T) {
+	// Only the head is sent: the declared length alone must be refused.
+	input := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Type: multipart/form-data; boundary=x\r\nContent-Length: %d\r\n\r\n", defaultMaxTotalSize+1)
+	r, err := RequestFromReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Release()
+
+	if err := r.ParseMultipartForm(MultipartLimits{}); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("got %v, want %v", err, ErrBodyTooLarge)
+	}
+}
@@ -0,0 +1,74 @@
+package request
+
+import (
+	"errors"
+)
+
+// ErrRequestComplete is returned by Feed when the current request is done
+// and has not been collected with Request yet.
+var ErrRequestComplete = errors.New("request complete")
+
+// Parser is a push-style request parser for callers that own the network
+// reads, such as event loops or tools replaying captured streams. It runs
+// the same state machine as RequestFromReader.
+//
+// Feed never buffers: bytes it does not consume must be passed again, with
+// more data appended, on the next call.
+type Parser struct {
+	req *Request
+}
+
+func NewParser() *Parser {
+	p := &Parser{}
+	p.reset()
+	return p
+}
+
+// Feed parses as much of data as it can and returns how many bytes it
+// consumed. It stops at the end of a request, so data may hold the start of
+// the next pipelined request; collect the finished one with Request before
+// feeding the rest.
+func (p *Parser) Feed(data []byte) (int, error) {
+	if p.Done() {
+		return 0, ErrRequestComplete
+	}
+
+	consumed := 0
+	for !p.Done() {
+		inHead := p.req.State < stateParsingBody
+		n, err := p.req.parse(data[consumed:])
+		if err != nil {
+			return consumed, err
+		}
+		if n == 0 {
+			break
+		}
+		if inHead {
+			p.req.raw = append(p.req.raw, data[consumed:consumed+n]...)
+		}
+		consumed += n
+	}
+	return consumed, nil
+}
+
+// Done reports whether a complete request is waiting to be collected.
+func (p *Parser) Done() bool {
+	return p.req.State == stateDone
+}
+
+// Request returns the completed request and readies the parser for the next
+// one. It returns nil while the current request is still incomplete. Fed
+// bytes are copied, so the request stays valid however data is reused, and
+// it may be handed back with Release when no longer needed.
+func (p *Parser) Request() *Request {
+	if !p.Done() {
+		return nil
+	}
+	req := p.req
+	p.reset()
+	return req
+}
+
+func (p *Parser) reset() {
+	p.req = requestPool.Get().(*Request)
+}
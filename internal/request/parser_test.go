@@ -0,0 +1,124 @@
+package request
+
+import (
+	"errors"
+	"testing"
+)
+
+// feedAll pushes data into p in chunks of chunkSize, carrying unconsumed
+// bytes over the way a network layer would, and returns every completed
+// request.
+func feedAll(p *Parser, data string, chunkSize int) ([]*Request, error) {
+	var reqs []*Request
+	var pending []byte
+
+	for i := 0; i < len(data); i += chunkSize {
+		pending = append(pending, data[i:min(i+chunkSize, len(data))]...)
+
+		for {
+			n, err := p.Feed(pending)
+			if err != nil {
+				return reqs, err
+			}
+			pending = pending[n:]
+			if !p.Done() {
+				break
+			}
+			reqs = append(reqs, p.Request())
+		}
+	}
+
+	return reqs, nil
+}
+
+func TestParserFeed(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           string
+		chunkSize      int
+		expectError    bool
+		expectedTarget []string
+		expectedBody   []string
+	}{
+		{
+			name:           "Single request in one feed",
+			data:           "GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+			chunkSize:      1024,
+			expectedTarget: []string{"/coffee"},
+			expectedBody:   []string{""},
+		},
+		{
+			name:           "Single byte feeds",
+			data:           "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello",
+			chunkSize:      1,
+			expectedTarget: []string{"/submit"},
+			expectedBody:   []string{"hello"},
+		},
+		{
+			name: "Pipelined requests",
+			data: "POST /a HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 3\r\n\r\nabc" +
+				"GET /b HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+			chunkSize:      7,
+			expectedTarget: []string{"/a", "/b"},
+			expectedBody:   []string{"abc", ""},
+		},
+		{
+			name:        "Invalid request line",
+			data:        "/coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+			chunkSize:   1024,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reqs, err := feedAll(NewParser(), tc.data, tc.chunkSize)
+
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(reqs) != len(tc.expectedTarget) {
+				t.Fatalf("got %d requests, want %d", len(reqs), len(tc.expectedTarget))
+			}
+
+			for i, r := range reqs {
+				if r.RequestLine.RequestTarget != tc.expectedTarget[i] {
+					t.Errorf("request %d: got target %q, want %q", i, r.RequestLine.RequestTarget, tc.expectedTarget[i])
+				}
+				if string(r.Body) != tc.expectedBody[i] {
+					t.Errorf("request %d: got body %q, want %q", i, r.Body, tc.expectedBody[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParserRequiresCollection(t *testing.T) {
+	p := NewParser()
+	data := []byte("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\nGET /next HTTP/1.1\r\n")
+
+	n, err := p.Feed(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := p.Feed(data[n:]); !errors.Is(err, ErrRequestComplete) {
+		t.Errorf("got error %v, want %v", err, ErrRequestComplete)
+	}
+
+	if r := p.Request(); r == nil || r.RequestLine.RequestTarget != "/" {
+		t.Fatalf("got request %v, want GET /", r)
+	}
+
+	if r := p.Request(); r != nil {
+		t.Errorf("got request %v from incomplete parser, want nil", r)
+	}
+}
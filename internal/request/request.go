@@ -2,16 +2,37 @@ package request
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode"
+	"unsafe"
 
 	"github.com/portbound/tcp-to-http/internal/headers"
 )
 
-const bufferSize = 8
+// bufferSize is the initial size of the read buffer. It comfortably fits a
+// typical request line and headers and grows only for larger ones.
+const bufferSize = 1024
+
+// maxBodyPrealloc caps how much of a declared Content-Length is allocated up
+// front, so a large claim cannot reserve memory before the bytes arrive.
+const maxBodyPrealloc = 1 << 16
+
+// maxHeaderBytes caps the request line and header section together, which
+// the read buffer must hold in full, so a client that never ends its head
+// cannot make the buffer grow without bound.
+const maxHeaderBytes = 1 << 20
+
+// maxPooledBuffer is the largest read buffer kept for reuse. Buffers grown
+// past it for an unusually large request are left to the garbage collector.
+const maxPooledBuffer = 1 << 16
+
 const (
 	stateParsingRequestLine = iota
 	stateParsingHeaders
@@ -19,91 +40,356 @@ const (
 	stateDone
 )
 
+// ErrUnsupportedExpectation is returned when a request carries an Expect
+// header other than "100-continue". Servers should answer it with 417.
+var ErrUnsupportedExpectation = errors.New("unsupported expectation")
+
+// ErrUnsupportedTransferEncoding is returned for a request sent with a
+// Transfer-Encoding, chunked included, since bodies are only read by
+// Content-Length. Servers should answer it with 501.
+var ErrUnsupportedTransferEncoding = errors.New("unsupported transfer encoding")
+
+// ErrHeaderTooLarge is returned when the request line and headers do not
+// end within maxHeaderBytes. Servers should answer it with 431.
+var ErrHeaderTooLarge = errors.New("request header section too large")
+
+// ErrMissingHost and ErrDuplicateHost are returned for HTTP/1.1 requests that
+// do not carry exactly one Host header.
+var (
+	ErrMissingHost   = errors.New("missing host header")
+	ErrDuplicateHost = errors.New("duplicate host header")
+)
+
 type Request struct {
 	RequestLine   RequestLine
 	Headers       headers.Headers
 	Body          []byte
 	ContentLength int
 	State         int
+
+	// RemoteAddr is the network address of the client, set by the server.
+	RemoteAddr string
+
+	// Form holds the query and form body fields and PostForm the body
+	// fields only. Both are nil until ParseForm or ParseMultipartForm is
+	// called.
+	Form     url.Values
+	PostForm url.Values
+
+	// MultipartForm is the parsed multipart body, including uploaded
+	// files, once ParseMultipartForm has been called.
+	MultipartForm *MultipartForm
+
+	// Continue is called once, before the body of an "Expect: 100-continue"
+	// request is read, so the server can send its interim response.
+	Continue func() error
+
+	stream  stream
+	inPlace bool
+	pending bool
+
+	// raw is the request line and header section exactly as received.
+	raw []byte
+}
+
+var requestPool = sync.Pool{
+	New: func() any {
+		r := &Request{}
+		r.Reset()
+		return r
+	},
+}
+
+// Reset clears r so it can parse another request, keeping the capacity of
+// its header map, body and read buffer.
+func (r *Request) Reset() {
+	if r.Headers == nil {
+		r.Headers = make(headers.Headers)
+	}
+	clear(r.Headers)
+
+	*r = Request{
+		Headers: r.Headers,
+		Body:    r.Body[:0],
+		State:   stateParsingRequestLine,
+		stream:  stream{buf: r.stream.buf},
+	}
+}
+
+// Release resets r and returns it to the pool RequestFromReader draws from.
+// r, its header map and its Body may not be used afterwards; for a request
+// from ParseInPlace, neither may any header value or part of the request
+// line.
+func (r *Request) Release() {
+	if cap(r.stream.buf) > maxPooledBuffer {
+		r.stream.buf = nil
+	}
+	if cap(r.Body) > maxBodyPrealloc {
+		r.Body = nil
+	}
+	r.Reset()
+	requestPool.Put(r)
+}
+
+// ReleaseBuffer returns r's read buffer to the pool but leaves r, its
+// header map and its Body to whoever still holds them, so they may be kept
+// for as long as needed. A deferred body can no longer be read afterwards.
+// It is for requests from RequestFromReader handed to code that may keep
+// parts of them; it does nothing for one from ParseInPlace, whose values
+// live in the buffer.
+func (r *Request) ReleaseBuffer() {
+	if r.inPlace {
+		return
+	}
+	buf := r.stream.buf
+	r.stream = stream{reader: r.stream.reader}
+	if cap(buf) > maxPooledBuffer {
+		return
+	}
+	next := &Request{stream: stream{buf: buf}}
+	next.Reset()
+	requestPool.Put(next)
 }
+
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
 }
 
-type ReadResult struct {
-	Data      []byte
-	BytesRead int
-	Err       error
+// stream is the single read buffer a request's head is parsed from.
+// Unparsed bytes live in buf[start:end]; it outlives RequestFromReader when
+// the body is deferred so parsing can resume where it stopped.
+//
+// Parsed header values point into buf, so it is never compacted. Body bytes
+// past what is already buffered are read straight into Request.Body.
+type stream struct {
+	reader io.Reader
+	buf    []byte
+	start  int
+	end    int
+}
+
+// fill reads more data from the underlying reader, moving to a larger buffer
+// first when there is no room left at the end, up to maxHeaderBytes. The old
+// buffer stays alive for as long as header values point into it.
+func (s *stream) fill() error {
+	if s.end == len(s.buf) {
+		if len(s.buf) >= maxHeaderBytes {
+			return ErrHeaderTooLarge
+		}
+		buf := make([]byte, min(max(len(s.buf)*2, bufferSize), maxHeaderBytes))
+		copy(buf, s.buf[:s.end])
+		s.buf = buf
+	}
+
+	n, err := s.reader.Read(s.buf[s.end:])
+	s.end += n
+	if n == 0 && err != nil {
+		return err
+	}
+	return nil
 }
 
+// RequestFromReader parses a request from reader. The body is left unread
+// when the client sent "Expect: 100-continue", and for multipart/form-data
+// so uploads can be streamed; call ReadBody to get it.
+//
+// The request comes from a pool; callers that are done with it may hand it
+// back with Release, or with ReleaseBuffer when something may still hold on
+// to it. Its header values and request line are copies, so they stay valid
+// after Release.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	dataChan := make(chan ReadResult)
-	buf := make([]byte, 0, bufferSize)
-	crlf := []byte{'\r', '\n'}
-	req := Request{
-		Headers: make(headers.Headers),
-		State:   stateParsingRequestLine,
+	return fromReader(reader, false)
+}
+
+// ParseInPlace is like RequestFromReader, but the request line and header
+// values point into the request's read buffer rather than being copied,
+// which saves an allocation per field. They are only valid until Release:
+// nothing taken from the request may be kept past it, whether stored,
+// logged later or handed to another goroutine.
+func ParseInPlace(reader io.Reader) (*Request, error) {
+	return fromReader(reader, true)
+}
+
+func fromReader(reader io.Reader, inPlace bool) (*Request, error) {
+	req := requestPool.Get().(*Request)
+	req.inPlace = inPlace
+	req.stream.reader = reader
+	if req.stream.buf == nil {
+		req.stream.buf = make([]byte, bufferSize)
 	}
 
-	go func() {
-		for {
-			buf := make([]byte, bufferSize)
-			n, err := reader.Read(buf)
-			dataChan <- ReadResult{
-				Data:      buf[:n],
-				BytesRead: n,
-				Err:       err,
-			}
-			if err != nil {
-				close(dataChan)
-				return
-			}
-		}
-	}()
+	if err := req.parseUntil(stateParsingBody); err != nil {
+		req.Release()
+		return nil, err
+	}
 
-	for req.State != stateDone {
-		stream, ok := <-dataChan
-		if !ok {
-			return nil, io.EOF
-		}
+	// The buffer is never compacted, so the head is still at its start.
+	req.raw = req.stream.buf[:req.stream.start]
+	if !inPlace {
+		req.raw = bytes.Clone(req.raw)
+	}
+
+	if req.State == stateParsingBody && (req.ExpectsContinue() || req.isMultipart()) {
+		req.pending = true
+		return req, nil
+	}
+
+	if err := req.parseUntil(stateDone); err != nil {
+		req.Release()
+		return nil, err
+	}
 
-		if len(buf)+stream.BytesRead > cap(buf) {
-			tmp := make([]byte, 0, cap(buf)*2)
-			tmp = append(tmp, buf...)
-			buf = tmp
+	return req, nil
+}
+
+// ExpectsContinue reports whether the client is holding back the body until
+// it receives a 100 Continue.
+func (r *Request) ExpectsContinue() bool {
+	return strings.EqualFold(r.Headers.Get("Expect"), "100-continue")
+}
+
+// isMultipart reports whether the body is multipart/form-data, which
+// RequestFromReader leaves unread so MultipartReader can stream it.
+func (r *Request) isMultipart() bool {
+	mediaType, _, _ := strings.Cut(r.Headers.Get("Content-Type"), ";")
+	return strings.EqualFold(strings.TrimSpace(mediaType), "multipart/form-data")
+}
+
+// ReadBody returns the request body, reading a deferred one from the
+// connection first. For an "Expect: 100-continue" body it calls Continue
+// before reading; handlers that reject a request without calling ReadBody
+// never cause the client to send it.
+func (r *Request) ReadBody() ([]byte, error) {
+	if !r.pending {
+		return r.Body, nil
+	}
+	r.pending = false
+
+	if r.Continue != nil && r.ExpectsContinue() {
+		if err := r.Continue(); err != nil {
+			return nil, err
 		}
+	}
+
+	if err := r.parseUntil(stateDone); err != nil {
+		return nil, err
+	}
+	return r.Body, nil
+}
+
+// Buffered returns a copy of the bytes read from the connection but not yet
+// consumed by the request, such as the first frames of a protocol the
+// client switched to right after its request.
+func (r *Request) Buffered() []byte {
+	s := &r.stream
+	return bytes.Clone(s.buf[s.start:s.end])
+}
 
-		buf = append(buf, stream.Data[:stream.BytesRead]...)
+// BodyReader returns the body as a stream. A body that has been read is
+// served from Body. A deferred one is read from the connection as the
+// caller consumes it, without being kept in Body; for one held back behind
+// "Expect: 100-continue", Continue is called on the first Read.
+func (r *Request) BodyReader() io.Reader {
+	if !r.pending {
+		return bytes.NewReader(r.Body)
+	}
+	r.pending = false
+	return &bodyReader{req: r, remaining: r.ContentLength - len(r.Body)}
+}
+
+type bodyReader struct {
+	req       *Request
+	remaining int
+	continued bool
+}
 
-		if req.State == stateParsingRequestLine || req.State == stateParsingHeaders {
-			if !bytes.Contains(buf, crlf) {
-				continue
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	if !b.continued {
+		b.continued = true
+		if b.req.Continue != nil && b.req.ExpectsContinue() {
+			if err := b.req.Continue(); err != nil {
+				return 0, err
 			}
 		}
+	}
+
+	s := &b.req.stream
+	p = p[:min(len(p), b.remaining)]
 
-		if req.State == stateParsingBody {
-			if len(buf) != req.ContentLength {
-				continue
+	var n int
+	var err error
+	if s.start < s.end {
+		n = copy(p, s.buf[s.start:s.end])
+		s.start += n
+	} else {
+		n, err = s.reader.Read(p)
+	}
+
+	b.remaining -= n
+	if b.remaining == 0 {
+		b.req.State = stateDone
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// parseUntil feeds data from the request's stream into the state machine
+// until the request reaches state. The state machine consumes nothing when
+// it needs more data, which is the only time another read is issued.
+func (r *Request) parseUntil(state int) error {
+	s := &r.stream
+	for r.State < state {
+		if r.State == stateParsingBody && s.start == s.end {
+			if err := r.readBody(); err != nil {
+				return err
 			}
+			continue
 		}
 
-		bytesParsed, err := req.parse(buf)
+		n, err := r.parse(s.buf[s.start:s.end])
 		if err != nil {
-			return nil, err
+			return err
 		}
+		s.start += n
 
-		if req.State == stateDone {
-			break
+		if n == 0 {
+			if err := s.fill(); err != nil {
+				return err
+			}
 		}
+	}
+	return nil
+}
 
-		tmp := make([]byte, 0, len(buf))
-		tmp = append(tmp, buf[bytesParsed:]...)
-		buf = tmp
+// readBody reads the rest of the body from the stream's reader directly
+// into r.Body, growing it no further than the declared length.
+func (r *Request) readBody() error {
+	if len(r.Body) == cap(r.Body) {
+		r.Body = slices.Grow(r.Body, min(r.ContentLength-len(r.Body), maxBodyPrealloc))
 	}
 
-	return &req, nil
+	end := min(cap(r.Body), r.ContentLength)
+	n, err := r.stream.reader.Read(r.Body[len(r.Body):end])
+	r.Body = r.Body[:len(r.Body)+n]
+
+	if len(r.Body) == r.ContentLength {
+		r.State = stateDone
+		return nil
+	}
+	if n == 0 && err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("error: body ended after %d of %d bytes", len(r.Body), r.ContentLength)
+		}
+		return err
+	}
+	return nil
 }
 
 func (r *Request) parse(buf []byte) (int, error) {
@@ -112,7 +398,12 @@ func (r *Request) parse(buf []byte) (int, error) {
 	}
 
 	if r.State == stateParsingHeaders {
-		bytesParsed, done, err := r.Headers.Parse(buf)
+		parse := r.Headers.Parse
+		if r.inPlace {
+			parse = r.Headers.ParseInPlace
+		}
+
+		bytesParsed, done, err := parse(buf)
 		if err != nil {
 			return 0, err
 		}
@@ -121,12 +412,32 @@ func (r *Request) parse(buf []byte) (int, error) {
 			return bytesParsed, nil
 		}
 
+		if err := validateHost(r.Headers); err != nil {
+			return 0, err
+		}
+
+		if expect := r.Headers.Get("Expect"); expect != "" && !r.ExpectsContinue() {
+			return 0, fmt.Errorf("%w: %q", ErrUnsupportedExpectation, expect)
+		}
+
+		// Read as if it were absent, the body would be taken for the next
+		// request on the connection.
+		if te, ok := r.Headers["transfer-encoding"]; ok {
+			return 0, fmt.Errorf("%w: %q", ErrUnsupportedTransferEncoding, te)
+		}
+
 		if val := r.Headers.Get("Content-Length"); val != "0" {
 			r.State = stateParsingBody
+			// RFC 9110 section 8.6 allows digits only, which Atoi alone
+			// does not enforce: it takes a sign as well.
+			if !isDigits(val) {
+				return bytesParsed, fmt.Errorf("error: invalid content length %q", val)
+			}
 			r.ContentLength, err = strconv.Atoi(val)
 			if err != nil {
 				return bytesParsed, err
 			}
+			r.Body = slices.Grow(r.Body[:0], min(r.ContentLength, maxBodyPrealloc))
 			return bytesParsed, nil
 		}
 
@@ -135,18 +446,7 @@ func (r *Request) parse(buf []byte) (int, error) {
 	}
 
 	if r.State == stateParsingBody {
-		value := r.Headers.Get("Content-Length")
-
-		contentLen, err := strconv.Atoi(value)
-		if err != nil {
-			return 0, err
-		}
-
-		bytesParsed, err := parseBody(r, buf, contentLen)
-		if err != nil {
-			return 0, err
-		}
-		return bytesParsed, nil
+		return parseBody(r, buf), nil
 	}
 	return 0, fmt.Errorf("error: unknown state")
 }
@@ -155,12 +455,24 @@ func parseRequestLine(r *Request, buf []byte) (int, error) {
 	crlf := []byte{'\r', '\n'}
 
 	lineEnd := bytes.Index(buf, crlf)
-	line := string(buf[:lineEnd])
-	fields := strings.Split(line, " ")
+	if lineEnd == -1 {
+		return 0, nil
+	}
 
-	if len(fields) != 3 {
+	var line string
+	if r.inPlace {
+		line = unsafe.String(unsafe.SliceData(buf), lineEnd)
+	} else {
+		line = string(buf[:lineEnd])
+	}
+
+	method, rest, _ := strings.Cut(line, " ")
+	target, version, _ := strings.Cut(rest, " ")
+	if strings.Count(line, " ") != 2 {
+		fields := strings.Split(line, " ")
 		return 0, fmt.Errorf("invalid Request Line. Expected 3 parts, got %d, %v", len(fields), fields)
 	}
+	fields := [3]string{method, target, version}
 
 	if fields[2] != "HTTP/1.1" {
 		return 0, fmt.Errorf("only HTTP/1.1 is supported. Got=%s", fields[2])
@@ -182,17 +494,42 @@ func parseRequestLine(r *Request, buf []byte) (int, error) {
 	return len(line) + len(crlf), nil
 }
 
-func parseBody(r *Request, buf []byte, contentLen int) (int, error) {
-	for _, b := range buf {
-		if b != byte(0) {
-			r.Body = append(r.Body, b)
-		}
+// validateHost enforces RFC 9112 section 3.2: a request has exactly one Host
+// field. Repeated fields are comma-joined by headers.Parse, and a comma can
+// never appear in a valid host, so it marks a duplicate.
+func validateHost(h headers.Headers) error {
+	host, ok := h["host"]
+	if !ok {
+		return ErrMissingHost
+	}
+	if strings.Contains(host, ",") {
+		return ErrDuplicateHost
 	}
+	return nil
+}
 
-	if len(r.Body) != contentLen {
-		return 0, fmt.Errorf("error: length of body %q does not match specified content length %q", len(r.Body), contentLen)
+// isDigits reports whether s is a non-empty run of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
 	}
+	return true
+}
 
-	r.State = stateDone
-	return contentLen, nil
+// parseBody appends as much of buf as the body still needs and reports how
+// many bytes it took. Anything past the declared length is left for the
+// caller.
+func parseBody(r *Request, buf []byte) int {
+	n := min(len(buf), r.ContentLength-len(r.Body))
+	r.Body = append(r.Body, buf[:n]...)
+
+	if len(r.Body) == r.ContentLength {
+		r.State = stateDone
+	}
+	return n
 }
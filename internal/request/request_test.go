@@ -1,8 +1,11 @@
 package request
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -222,6 +225,32 @@ func TestBodyParse(t *testing.T) {
 			expectError:           false,
 			expectedContentLength: 0,
 		},
+		{
+			name: "Binary body",
+			input: &chunkReader{
+				data: "POST /submit HTTP/1.1\r\n" +
+					"Host: localhost:42069\r\n" +
+					"Content-Length: 5\r\n" +
+					"\r\n" +
+					"a\x00b\x00c",
+				numBytesPerRead: 3,
+			},
+			expectedContentLength: 5,
+			expectedBody:          "a\x00b\x00c",
+		},
+		{
+			name: "Body longer than reported content length",
+			input: &chunkReader{
+				data: "POST /submit HTTP/1.1\r\n" +
+					"Host: localhost:42069\r\n" +
+					"Content-Length: 5\r\n" +
+					"\r\n" +
+					"hello world!\n",
+				numBytesPerRead: 64,
+			},
+			expectedContentLength: 5,
+			expectedBody:          "hello",
+		},
 		{
 			name: "No content length but body exists",
 			input: &chunkReader{
@@ -234,6 +263,43 @@ func TestBodyParse(t *testing.T) {
 			expectError:           false,
 			expectedContentLength: 0,
 		},
+		{
+			name: "Signed content length",
+			input: &chunkReader{
+				data: "POST /submit HTTP/1.1\r\n" +
+					"Host: localhost:42069\r\n" +
+					"Content-Length: +5\r\n" +
+					"\r\n" +
+					"hello",
+				numBytesPerRead: 8,
+			},
+			expectError: true,
+		},
+		{
+			name: "Repeated content length",
+			input: &chunkReader{
+				data: "POST /submit HTTP/1.1\r\n" +
+					"Host: localhost:42069\r\n" +
+					"Content-Length: 5\r\n" +
+					"Content-Length: 6\r\n" +
+					"\r\n" +
+					"hello!",
+				numBytesPerRead: 8,
+			},
+			expectError: true,
+		},
+		{
+			name: "Chunked body",
+			input: &chunkReader{
+				data: "POST /submit HTTP/1.1\r\n" +
+					"Host: localhost:42069\r\n" +
+					"Transfer-Encoding: chunked\r\n" +
+					"\r\n" +
+					"5\r\nhello\r\n0\r\n\r\n",
+				numBytesPerRead: 8,
+			},
+			expectError: true,
+		},
 	}
 
 	for _, tc := range tests {
@@ -265,3 +331,320 @@ func TestBodyParse(t *testing.T) {
 		})
 	}
 }
+
+func TestExpectContinue(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          io.Reader
+		expectError    error
+		expectContinue bool
+		expectedBody   string
+	}{
+		{
+			name: "Body deferred until read",
+			input: &chunkReader{
+				data: "POST /upload HTTP/1.1\r\n" +
+					"Host: localhost:42069\r\n" +
+					"Content-Length: 13\r\n" +
+					"Expect: 100-continue\r\n" +
+					"\r\n" +
+					"hello world!\n",
+				numBytesPerRead: 3,
+			},
+			expectContinue: true,
+			expectedBody:   "hello world!\n",
+		},
+		{
+			name: "Expectation is case-insensitive",
+			input: &chunkReader{
+				data: "POST /upload HTTP/1.1\r\n" +
+					"Host: localhost:42069\r\n" +
+					"Content-Length: 5\r\n" +
+					"Expect: 100-Continue\r\n" +
+					"\r\n" +
+					"hello",
+				numBytesPerRead: 8,
+			},
+			expectContinue: true,
+			expectedBody:   "hello",
+		},
+		{
+			name: "No body means no interim response",
+			input: &chunkReader{
+				data: "POST /upload HTTP/1.1\r\n" +
+					"Host: localhost:42069\r\n" +
+					"Expect: 100-continue\r\n" +
+					"\r\n",
+				numBytesPerRead: 8,
+			},
+			expectContinue: false,
+		},
+		{
+			name: "Unknown expectation",
+			input: &chunkReader{
+				data: "POST /upload HTTP/1.1\r\n" +
+					"Host: localhost:42069\r\n" +
+					"Content-Length: 5\r\n" +
+					"Expect: 200-ok\r\n" +
+					"\r\n" +
+					"hello",
+				numBytesPerRead: 8,
+			},
+			expectError: ErrUnsupportedExpectation,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(tc.input)
+
+			if tc.expectError != nil {
+				if !errors.Is(err, tc.expectError) {
+					t.Errorf("got error %v, want %v", err, tc.expectError)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			continued := false
+			r.Continue = func() error {
+				if len(r.Body) != 0 {
+					t.Errorf("body read before continue: %q", r.Body)
+				}
+				continued = true
+				return nil
+			}
+
+			body, err := r.ReadBody()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if continued != tc.expectContinue {
+				t.Errorf("continue sent: got %v, want %v", continued, tc.expectContinue)
+			}
+			if string(body) != tc.expectedBody {
+				t.Errorf("got body %q, want %q", body, tc.expectedBody)
+			}
+		})
+	}
+}
+
+func TestHostValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       io.Reader
+		expectError error
+	}{
+		{
+			name:  "Single host",
+			input: strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"),
+		},
+		{
+			name:  "Empty host value",
+			input: strings.NewReader("GET / HTTP/1.1\r\nHost:\r\n\r\n"),
+		},
+		{
+			name:        "Missing host",
+			input:       strings.NewReader("GET / HTTP/1.1\r\nAccept: */*\r\n\r\n"),
+			expectError: ErrMissingHost,
+		},
+		{
+			name:        "Repeated host",
+			input:       strings.NewReader("GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n"),
+			expectError: ErrDuplicateHost,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := RequestFromReader(tc.input)
+			if !errors.Is(err, tc.expectError) {
+				t.Errorf("got error %v, want %v", err, tc.expectError)
+			}
+		})
+	}
+}
+
+var benchRequests = []struct {
+	name string
+	data string
+}{
+	{
+		name: "GET",
+		data: "GET /coffee HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"User-Agent: curl/7.81.0\r\n" +
+			"Accept: */*\r\n" +
+			"\r\n",
+	},
+	{
+		name: "POST",
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"User-Agent: curl/7.81.0\r\n" +
+			"Content-Type: application/json\r\n" +
+			"Content-Length: 1024\r\n" +
+			"\r\n" +
+			strings.Repeat("x", 1024),
+	},
+}
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	benchmarkParse(b, RequestFromReader)
+}
+
+func BenchmarkParseInPlace(b *testing.B) {
+	benchmarkParse(b, ParseInPlace)
+}
+
+func benchmarkParse(b *testing.B, parse func(io.Reader) (*Request, error)) {
+	for _, bc := range benchRequests {
+		b.Run(bc.name, func(b *testing.B) {
+			r := strings.NewReader(bc.data)
+			b.SetBytes(int64(len(bc.data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				r.Reset(bc.data)
+				req, err := parse(r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				req.Release()
+			}
+		})
+	}
+}
+
+func TestRequestFromReaderNoGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for range 100 {
+		RequestFromReader(strings.NewReader("/coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+		RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutines: got %d after parsing, had %d before", after, before)
+	}
+}
+
+func TestRequestRelease(t *testing.T) {
+	first, err := RequestFromReader(strings.NewReader("POST /first HTTP/1.1\r\nHost: first.example\r\nContent-Length: 5\r\n\r\nhello"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first.Release()
+
+	second, err := RequestFromReader(strings.NewReader("GET /second HTTP/1.1\r\nHost: second.example\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if second.RequestLine.RequestTarget != "/second" {
+		t.Errorf("got target %q, want %q", second.RequestLine.RequestTarget, "/second")
+	}
+	if got := second.Headers.Get("Host"); got != "second.example" {
+		t.Errorf("got host %q, want %q", got, "second.example")
+	}
+	if len(second.Headers) != 1 {
+		t.Errorf("got headers %v, want only host", second.Headers)
+	}
+	if len(second.Body) != 0 || second.ContentLength != 0 {
+		t.Errorf("got body %q with content length %d, want none", second.Body, second.ContentLength)
+	}
+}
+
+func TestRequestFromReaderCopies(t *testing.T) {
+	first, err := RequestFromReader(strings.NewReader("GET /first HTTP/1.1\r\nHost: first.example\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	target, host := first.RequestLine.RequestTarget, first.Headers.Get("Host")
+	first.Release()
+
+	// Parsing in place reuses the read buffer the first request had.
+	second, err := ParseInPlace(strings.NewReader("GET /other HTTP/1.1\r\nHost: other.example\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer second.Release()
+
+	if target != "/first" || host != "first.example" {
+		t.Errorf("got target %q and host %q after release, want the first request's", target, host)
+	}
+}
+
+func TestRequestReleaseBuffer(t *testing.T) {
+	first, err := RequestFromReader(strings.NewReader("POST /first HTTP/1.1\r\nHost: first.example\r\nContent-Length: 10\r\n\r\nFIRST-BODY"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h, body := first.Headers, first.Body
+	first.ReleaseBuffer()
+
+	for range 10 {
+		next, err := RequestFromReader(strings.NewReader("POST /second HTTP/1.1\r\nHost: second.example\r\nContent-Length: 10\r\n\r\nSECOND-BOD"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		next.Release()
+	}
+
+	if got := h.Get("Host"); got != "first.example" {
+		t.Errorf("got host %q, want %q", got, "first.example")
+	}
+	if string(body) != "FIRST-BODY" {
+		t.Errorf("got body %q, want %q", body, "FIRST-BODY")
+	}
+	if first.RequestLine.RequestTarget != "/first" {
+		t.Errorf("got target %q, want %q", first.RequestLine.RequestTarget, "/first")
+	}
+}
+
+// endlessHeaders sends a request line followed by header lines that never
+// end, counting the bytes read.
+type endlessHeaders struct {
+	pending string
+	lines   int
+	sent    int
+}
+
+func (e *endlessHeaders) Read(p []byte) (int, error) {
+	if e.pending == "" {
+		if e.lines == 0 {
+			e.pending = "GET / HTTP/1.1\r\nHost: localhost\r\n"
+		} else {
+			e.pending = fmt.Sprintf("X-Filler-%d: aaaaaaaaaaaaaaaa\r\n", e.lines)
+		}
+		e.lines++
+	}
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	e.sent += n
+	return n, nil
+}
+
+func TestHeaderSectionLimit(t *testing.T) {
+	r := &endlessHeaders{}
+	_, err := RequestFromReader(r)
+	if !errors.Is(err, ErrHeaderTooLarge) {
+		t.Fatalf("got %v, want %v", err, ErrHeaderTooLarge)
+	}
+	if r.sent > maxHeaderBytes {
+		t.Errorf("read %d bytes, want at most %d", r.sent, maxHeaderBytes)
+	}
+
+	big := "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 100_000) + "\r\n\r\n"
+	req, err := RequestFromReader(strings.NewReader(big))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer req.Release()
+	if got := len(req.Headers.Get("X-Big")); got != 100_000 {
+		t.Errorf("got a %d byte value, want 100000", got)
+	}
+}
@@ -0,0 +1,136 @@
+package request
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+)
+
+// ErrNotReceived is returned by WriteExact for requests that were built in
+// code rather than parsed, or changed since by DecodeBody, so have no
+// original bytes to reproduce.
+var ErrNotReceived = errors.New("request was not parsed from the wire")
+
+// ErrInvalidHead is returned by Write and WriteHead for a request line or
+// field name that would not survive being written out, such as a target
+// holding a line break. Bad field values are reported as
+// headers.ErrInvalidValue.
+var ErrInvalidHead = errors.New("invalid request head")
+
+// Write serializes r in a normalized form: a single space between the
+// request line's parts, Host first and the other fields sorted with
+// canonical capitalization, repeated fields already joined into one line,
+// and a Content-Length that matches Body.
+//
+// A body still held back behind "Expect: 100-continue" is read first.
+func (r *Request) Write(w io.Writer) error {
+	body, err := r.ReadBody()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := r.writeHead(bw, len(body)); err != nil {
+		return err
+	}
+	bw.Write(body)
+	return bw.Flush()
+}
+
+// WriteHead writes the normalized request line and header section alone,
+// with Content-Length taken from r.ContentLength, so the body can be
+// streamed after it from BodyReader.
+func (r *Request) WriteHead(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if err := r.writeHead(bw, r.ContentLength); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (r *Request) writeHead(bw *bufio.Writer, contentLength int) error {
+	version := r.RequestLine.HttpVersion
+	if version == "" {
+		version = "HTTP/1.1"
+	}
+	// Checked before anything is buffered, so a bad head sends nothing.
+	if err := checkHead(r.RequestLine.Method, r.RequestLine.RequestTarget, version, r.Headers); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(bw, "%s %s %s\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget, version)
+
+	if host, ok := r.Headers["host"]; ok {
+		fmt.Fprintf(bw, "Host: %s\r\n", host)
+	}
+
+	names := make([]string, 0, len(r.Headers))
+	for name := range r.Headers {
+		if name != "host" && name != "content-length" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		fmt.Fprintf(bw, "%s: %s\r\n", headers.CanonicalKey(name), r.Headers[name])
+	}
+
+	if _, ok := r.Headers["content-length"]; ok || contentLength > 0 {
+		fmt.Fprintf(bw, "Content-Length: %s\r\n", strconv.Itoa(contentLength))
+	}
+
+	bw.WriteString("\r\n")
+	return nil
+}
+
+// checkHead returns an error for any part of the head that would break out
+// of its line: a request line part with a space, CR, LF or NUL, a field name
+// that is not a token, or a field value with CR, LF or NUL.
+func checkHead(method, target, version string, h headers.Headers) error {
+	if !headers.IsToken(method) {
+		return fmt.Errorf("%w: method %q", ErrInvalidHead, method)
+	}
+	for _, part := range []string{target, version} {
+		if part == "" || strings.ContainsAny(part, " \r\n\x00") {
+			return fmt.Errorf("%w: request line part %q", ErrInvalidHead, part)
+		}
+	}
+	for name, value := range h {
+		if !headers.IsToken(name) {
+			return fmt.Errorf("%w: field name %q", ErrInvalidHead, name)
+		}
+		if !headers.ValidValue(value) {
+			return fmt.Errorf("%w for %s: %q", headers.ErrInvalidValue, name, value)
+		}
+	}
+	return nil
+}
+
+// WriteExact writes r byte for byte as it was received: the original
+// request line and header section, with their casing, ordering, spacing and
+// repeated fields, followed by the body.
+//
+// A body still held back behind "Expect: 100-continue" is read first.
+func (r *Request) WriteExact(w io.Writer) error {
+	if r.raw == nil {
+		return ErrNotReceived
+	}
+
+	body, err := r.ReadBody()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(r.raw); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
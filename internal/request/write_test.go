@@ -0,0 +1,198 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+)
+
+func TestRequestWrite(t *testing.T) {
+	r := &Request{
+		RequestLine: RequestLine{HttpVersion: "HTTP/1.1", RequestTarget: "/submit", Method: "POST"},
+		Headers: headers.Headers{
+			"user-agent":     "curl/7.81.0",
+			"host":           "localhost:42069",
+			"content-type":   "text/plain",
+			"content-length": "999",
+			"x-request-id":   "abc",
+		},
+		Body: []byte("hello"),
+	}
+
+	want := "POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"User-Agent: curl/7.81.0\r\n" +
+		"X-Request-Id: abc\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello"
+
+	buf := &bytes.Buffer{}
+	if err := r.Write(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != want {
+		t.Errorf("got\n%q\nwant\n%q", buf.String(), want)
+	}
+
+	if err := r.WriteExact(buf); !errors.Is(err, ErrNotReceived) {
+		t.Errorf("got error %v, want %v", err, ErrNotReceived)
+	}
+}
+
+func TestRequestWriteExact(t *testing.T) {
+	inputs := []string{
+		"GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		"GET /x HTTP/1.1\r\nuser-AGENT: curl\r\nHost:   spaced.example  \r\nAccept: a\r\nAccept: b\r\n\r\n",
+		"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\n",
+	}
+
+	for _, input := range inputs {
+		r, err := RequestFromReader(&chunkReader{data: input, numBytesPerRead: 3})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		buf := &bytes.Buffer{}
+		if err := r.WriteExact(buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if buf.String() != input {
+			t.Errorf("RequestFromReader: got %q, want %q", buf.String(), input)
+		}
+
+		reqs, err := feedAll(NewParser(), input, 5)
+		if err != nil || len(reqs) != 1 {
+			t.Fatalf("got %d requests and error %v, want 1 request", len(reqs), err)
+		}
+		buf.Reset()
+		if err := reqs[0].WriteExact(buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if buf.String() != input {
+			t.Errorf("Parser: got %q, want %q", buf.String(), input)
+		}
+	}
+}
+
+// randomRequest builds a request that Write can represent and the parser
+// accepts: a token method, an origin-form target and lowercase field names.
+func randomRequest(rng *rand.Rand) *Request {
+	methods := []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"}
+	token := func(n int) string {
+		const chars = "abcdefghijklmnopqrstuvwxyz0123456789-_."
+		b := make([]byte, 1+rng.Intn(n))
+		for i := range b {
+			b[i] = chars[rng.Intn(len(chars))]
+		}
+		return string(b)
+	}
+
+	r := &Request{
+		RequestLine: RequestLine{
+			HttpVersion:   "HTTP/1.1",
+			RequestTarget: "/" + token(20),
+			Method:        methods[rng.Intn(len(methods))],
+		},
+		Headers: headers.Headers{"host": token(10) + ":" + strconv.Itoa(rng.Intn(65536))},
+	}
+
+	for range rng.Intn(8) {
+		r.Headers["x-"+token(10)] = token(10) + " " + token(10)
+	}
+
+	if rng.Intn(2) == 0 {
+		r.Body = []byte(strings.Repeat(token(10), rng.Intn(50)))
+		r.Headers["content-length"] = strconv.Itoa(len(r.Body))
+	}
+	return r
+}
+
+func TestRequestWriteRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for i := range 500 {
+		want := randomRequest(rng)
+
+		buf := &bytes.Buffer{}
+		if err := want.Write(buf); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		got, err := RequestFromReader(&chunkReader{data: buf.String(), numBytesPerRead: 1 + rng.Intn(16)})
+		if err != nil {
+			t.Fatalf("case %d: parsing %q: %v", i, buf.String(), err)
+		}
+
+		if got.RequestLine != want.RequestLine {
+			t.Errorf("case %d: got request line %+v, want %+v", i, got.RequestLine, want.RequestLine)
+		}
+		if !reflect.DeepEqual(map[string]string(got.Headers), map[string]string(want.Headers)) {
+			t.Errorf("case %d: got headers %v, want %v", i, got.Headers, want.Headers)
+		}
+		if !bytes.Equal(got.Body, want.Body) {
+			t.Errorf("case %d: got body %q, want %q", i, got.Body, want.Body)
+		}
+	}
+}
+
+func TestRequestWriteRejectsLineBreaks(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    RequestLine
+		headers headers.Headers
+		wantErr error
+	}{
+		{name: "Method", line: RequestLine{Method: "GET /x HTTP/1.1\r\nX:", RequestTarget: "/"}, wantErr: ErrInvalidHead},
+		{name: "Target", line: RequestLine{Method: "GET", RequestTarget: "/\r\nX-Injected: yes"}, wantErr: ErrInvalidHead},
+		{name: "Field name", line: RequestLine{Method: "GET", RequestTarget: "/"}, headers: headers.Headers{"x-a\r\nx-b": "1"}, wantErr: ErrInvalidHead},
+		{name: "Field value", line: RequestLine{Method: "GET", RequestTarget: "/"}, headers: headers.Headers{"set-cookie": "a\nTransfer-Encoding: chunked"}, wantErr: headers.ErrInvalidValue},
+		{name: "NUL in value", line: RequestLine{Method: "GET", RequestTarget: "/"}, headers: headers.Headers{"x-a": "a\x00b"}, wantErr: headers.ErrInvalidValue},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := headers.Headers{"host": "localhost"}
+			maps.Copy(h, tc.headers)
+			r := &Request{RequestLine: tc.line, Headers: h}
+
+			buf := &bytes.Buffer{}
+			if err := r.Write(buf); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Write: got %v, want %v", err, tc.wantErr)
+			}
+			if err := r.WriteHead(buf); !errors.Is(err, tc.wantErr) {
+				t.Errorf("WriteHead: got %v, want %v", err, tc.wantErr)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("got %q written, want nothing", buf.String())
+			}
+		})
+	}
+}
+
+func TestRequestRepeatedSetCookieStaysOneLine(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nSet-Cookie: a\r\nSet-Cookie: Transfer-Encoding: chunked\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Release()
+
+	buf := &bytes.Buffer{}
+	if err := r.WriteHead(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Set-Cookie: a, Transfer-Encoding: chunked\r\n" +
+		"\r\n"
+	if buf.String() != want {
+		t.Errorf("got\n%q\nwant\n%q", buf.String(), want)
+	}
+}
@@ -0,0 +1,90 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+)
+
+// chunkedReader decodes a chunked body from br, storing any trailer fields
+// in trailers once the last chunk has been read.
+type chunkedReader struct {
+	br        *bufio.Reader
+	trailers  headers.Headers
+	remaining int64
+	done      bool
+	err       error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if c.remaining == 0 && !c.done {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	if c.done {
+		c.err = io.EOF
+		return 0, c.err
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.remaining -= int64(n)
+
+	if c.remaining == 0 && err == nil {
+		err = c.readCRLF()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+// nextChunk reads a chunk-size line, ignoring chunk extensions. A size of
+// zero ends the body and is followed by the trailer section.
+func (c *chunkedReader) nextChunk() error {
+	line, err := readLine(c.br)
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	size, _, _ := bytes.Cut(line, []byte{';'})
+	n, err := strconv.ParseInt(string(bytes.TrimSpace(size)), 16, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid chunk size %q", line)
+	}
+
+	if n == 0 {
+		c.done = true
+		return readFields(c.br, c.trailers)
+	}
+
+	c.remaining = n
+	return nil
+}
+
+func (c *chunkedReader) readCRLF() error {
+	line, err := readLine(c.br)
+	if err != nil {
+		return err
+	}
+	if len(line) != 0 {
+		return fmt.Errorf("invalid chunk: missing CRLF after data")
+	}
+	return nil
+}
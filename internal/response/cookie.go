@@ -0,0 +1,173 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+)
+
+// ErrInvalidCookie is returned for a cookie that cannot be written as a
+// valid Set-Cookie field.
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+// SameSite is the SameSite attribute of a cookie.
+type SameSite int
+
+const (
+	// SameSiteDefault omits the attribute, leaving the choice to the
+	// browser.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// Cookie is a cookie to send in a Set-Cookie field.
+type Cookie struct {
+	Name  string
+	Value string
+
+	// Expires is omitted when zero.
+	Expires time.Time
+	// MaxAge is in seconds. Zero omits the attribute and a negative value
+	// sends Max-Age=0, which deletes the cookie.
+	MaxAge int
+
+	Domain      string
+	Path        string
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Format returns the cookie as a Set-Cookie field value, as described by
+// RFC 6265. A value containing a space or comma is sent quoted. SameSite=None
+// and Partitioned cookies must be Secure, since browsers reject them
+// otherwise.
+func (c Cookie) Format() (string, error) {
+	if !headers.IsToken(c.Name) {
+		return "", fmt.Errorf("%w: name %q is not a token", ErrInvalidCookie, c.Name)
+	}
+	value, err := formatCookieValue(c.Value)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(value)
+
+	if c.Path != "" {
+		if strings.ContainsFunc(c.Path, func(r rune) bool { return r == ';' || r < 0x20 || r == 0x7f }) {
+			return "", fmt.Errorf("%w: invalid path %q", ErrInvalidCookie, c.Path)
+		}
+		b.WriteString("; Path=")
+		b.WriteString(c.Path)
+	}
+	if c.Domain != "" {
+		domain := strings.TrimPrefix(c.Domain, ".")
+		if !validCookieDomain(domain) {
+			return "", fmt.Errorf("%w: invalid domain %q", ErrInvalidCookie, c.Domain)
+		}
+		b.WriteString("; Domain=")
+		b.WriteString(domain)
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=")
+		b.WriteString(c.Expires.UTC().Format(TimeFormat))
+	}
+	switch {
+	case c.MaxAge > 0:
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.SameSite != SameSiteDefault {
+		if c.SameSite == SameSiteNone && !c.Secure {
+			return "", fmt.Errorf("%w: SameSite=None requires Secure", ErrInvalidCookie)
+		}
+		b.WriteString("; SameSite=")
+		b.WriteString(c.SameSite.String())
+	}
+	if c.Partitioned {
+		if !c.Secure {
+			return "", fmt.Errorf("%w: Partitioned requires Secure", ErrInvalidCookie)
+		}
+		b.WriteString("; Partitioned")
+	}
+	return b.String(), nil
+}
+
+// SetCookie adds a Set-Cookie field for c alongside any already set.
+func (w *Writer) SetCookie(c Cookie) error {
+	v, err := c.Format()
+	if err != nil {
+		return err
+	}
+	return w.headers.Add("Set-Cookie", v)
+}
+
+// formatCookieValue checks value holds only cookie-octets, plus space and
+// comma which are allowed once the value is quoted.
+func formatCookieValue(value string) (string, error) {
+	quote := false
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case ch == ' ' || ch == ',':
+			quote = true
+		case ch <= 0x20 || ch >= 0x7f || ch == '"' || ch == ';' || ch == '\\':
+			return "", fmt.Errorf("%w: invalid byte %q in value", ErrInvalidCookie, ch)
+		}
+	}
+	if quote {
+		return `"` + value + `"`, nil
+	}
+	return value, nil
+}
+
+// validCookieDomain reports whether domain is a hostname: dot-separated
+// labels of letters, digits and hyphens, none starting or ending with a
+// hyphen.
+func validCookieDomain(domain string) bool {
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	for label := range strings.SplitSeq(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			ch := label[i]
+			if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieFormat(t *testing.T) {
+	expires := time.Date(2026, time.October, 21, 9, 28, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	tests := []struct {
+		name      string
+		cookie    Cookie
+		expected  string
+		expectErr bool
+	}{
+		{name: "Plain", cookie: Cookie{Name: "id", Value: "a3fWa"}, expected: "id=a3fWa"},
+		{name: "Empty value", cookie: Cookie{Name: "id"}, expected: "id="},
+		{
+			name: "All attributes",
+			cookie: Cookie{
+				Name: "session", Value: "abc", Path: "/app", Domain: ".example.com", Expires: expires,
+				MaxAge: 3600, HttpOnly: true, Secure: true, SameSite: SameSiteNone, Partitioned: true,
+			},
+			expected: "session=abc; Path=/app; Domain=example.com; Expires=Wed, 21 Oct 2026 07:28:00 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=None; Partitioned",
+		},
+		{name: "SameSite Lax", cookie: Cookie{Name: "a", Value: "1", SameSite: SameSiteLax}, expected: "a=1; SameSite=Lax"},
+		{name: "Delete", cookie: Cookie{Name: "a", MaxAge: -1}, expected: "a=; Max-Age=0"},
+		{name: "Quoted value", cookie: Cookie{Name: "a", Value: "x y,z"}, expected: `a="x y,z"`},
+		{name: "Invalid name", cookie: Cookie{Name: "a b", Value: "1"}, expectErr: true},
+		{name: "Empty name", cookie: Cookie{Value: "1"}, expectErr: true},
+		{name: "Semicolon in value", cookie: Cookie{Name: "a", Value: "1;2"}, expectErr: true},
+		{name: "Quote in value", cookie: Cookie{Name: "a", Value: `"1"`}, expectErr: true},
+		{name: "Non-ASCII value", cookie: Cookie{Name: "a", Value: "é"}, expectErr: true},
+		{name: "Invalid domain", cookie: Cookie{Name: "a", Value: "1", Domain: "exa mple.com"}, expectErr: true},
+		{name: "Invalid path", cookie: Cookie{Name: "a", Value: "1", Path: "/;x"}, expectErr: true},
+		{name: "SameSite None without Secure", cookie: Cookie{Name: "a", Value: "1", SameSite: SameSiteNone}, expectErr: true},
+		{name: "Partitioned without Secure", cookie: Cookie{Name: "a", Value: "1", Partitioned: true}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.cookie.Format()
+			if tc.expectErr {
+				if !errors.Is(err, ErrInvalidCookie) {
+					t.Fatalf("got error %v, want %v", err, ErrInvalidCookie)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("got %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	for _, c := range []Cookie{{Name: "a", Value: "1"}, {Name: "b", Value: "2", HttpOnly: true}} {
+		if err := w.SetCookie(c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.SetCookie(Cookie{Name: "bad name"}); err == nil {
+		t.Fatal("expected an error for an invalid cookie")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := buf.String(); !strings.Contains(got, "Set-Cookie: a=1\r\nSet-Cookie: b=2; HttpOnly\r\n") {
+		t.Errorf("got %q, want one Set-Cookie line per cookie", got)
+	}
+
+	resp, err := ResponseFromReader(buf, "GET")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := resp.Headers.Values("Set-Cookie"); len(got) != 2 || got[0] != "a=1" || got[1] != "b=2; HttpOnly" {
+		t.Errorf("got parsed Set-Cookie values %q", got)
+	}
+}
@@ -0,0 +1,23 @@
+package response
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// WriteJSON sends v encoded as JSON with statusCode, setting Content-Type
+// to application/json and Content-Length to the encoded size. Nothing is
+// written if v cannot be encoded. It should be the only body written to w.
+func (w *Writer) WriteJSON(statusCode StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	w.SetStatusCode(statusCode)
+	w.headers.Set("Content-Type", "application/json")
+	w.headers.Set("Content-Length", strconv.Itoa(len(body)))
+	_, err = w.Write(body)
+	return err
+}
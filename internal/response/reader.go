@@ -0,0 +1,250 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+)
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+
+	// Trailers holds fields sent after a chunked body. It is filled once
+	// the body has been read to the end.
+	Trailers headers.Headers
+}
+
+// ResponseFromReader reads a complete response, skipping any interim 1xx
+// responses before it. method is the method of the request being answered,
+// which decides whether a body follows.
+//
+// If reader is a *bufio.Reader it is read from directly, so bytes after the
+// response stay buffered for the next one on a kept-alive connection.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(reader)
+	}
+
+	for {
+		resp, err := ReadResponseHead(br)
+		if err != nil {
+			return nil, err
+		}
+
+		code := resp.StatusLine.StatusCode
+		if code >= 100 && code < 200 && code != StatusSwitchingProtocols {
+			continue
+		}
+
+		resp.Body, err = io.ReadAll(resp.BodyReader(br, method))
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// ReadResponseHead reads a status line and headers from br and leaves the
+// body unread, so callers can stream it with BodyReader.
+func ReadResponseHead(br *bufio.Reader) (*Response, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+
+	statusLine, err := parseStatusLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := Response{
+		StatusLine: statusLine,
+		Headers:    make(headers.Headers),
+		Trailers:   make(headers.Headers),
+	}
+
+	if err := readFields(br, resp.Headers); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// HasBody reports whether a response to method carries a body at all.
+// Responses to HEAD, 1xx, 204 and 304 never do, whatever their headers say.
+func (r *Response) HasBody(method string) bool {
+	code := r.StatusLine.StatusCode
+	switch {
+	case method == "HEAD":
+		return false
+	case code >= 100 && code < 200:
+		return false
+	case code == StatusNoContent || code == StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// Chunked reports whether the body is framed with chunked transfer coding.
+func (r *Response) Chunked() bool {
+	codings := strings.Split(r.Headers.Get("Transfer-Encoding"), ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+// closeDelimited reports whether the body runs until the connection is
+// closed despite a Transfer-Encoding: without chunked as the final coding
+// nothing else marks its end (RFC 9112 section 6.3).
+func (r *Response) closeDelimited() bool {
+	_, ok := r.Headers["transfer-encoding"]
+	return ok && !r.Chunked()
+}
+
+// BodyReader returns a reader over the response body that follows the head
+// in br. Transfer-Encoding takes precedence over Content-Length: a chunked
+// body is decoded, and one with any other final coding runs until the
+// connection is closed, as does a body with neither header.
+func (r *Response) BodyReader(br *bufio.Reader, method string) io.Reader {
+	if !r.HasBody(method) {
+		return bytes.NewReader(nil)
+	}
+
+	if r.Chunked() {
+		return &chunkedReader{br: br, trailers: r.Trailers}
+	}
+	if r.closeDelimited() {
+		return br
+	}
+
+	if val, ok := r.Headers["content-length"]; ok {
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || n < 0 {
+			return &errReader{fmt.Errorf("invalid content length %q", val)}
+		}
+		return &lengthReader{r: br, remaining: n}
+	}
+
+	return br
+}
+
+// KeepAlive reports whether the connection can carry another exchange
+// after this response has been read in full.
+func (r *Response) KeepAlive(method string) bool {
+	if strings.EqualFold(r.Headers.Get("Connection"), "close") {
+		return false
+	}
+	if r.StatusLine.HttpVersion != "HTTP/1.1" {
+		return false
+	}
+	if !r.HasBody(method) || r.Chunked() {
+		return true
+	}
+	if r.closeDelimited() {
+		return false
+	}
+	_, ok := r.Headers["content-length"]
+	return ok
+}
+
+func parseStatusLine(line []byte) (StatusLine, error) {
+	version, rest, _ := strings.Cut(string(line), " ")
+	code, reason, _ := strings.Cut(rest, " ")
+
+	if version != "HTTP/1.1" && version != "HTTP/1.0" {
+		return StatusLine{}, fmt.Errorf("unsupported HTTP version. Got=%s", version)
+	}
+
+	if len(code) != 3 {
+		return StatusLine{}, fmt.Errorf("invalid status code. Got=%q", code)
+	}
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || statusCode < 100 {
+		return StatusLine{}, fmt.Errorf("invalid status code. Got=%q", code)
+	}
+
+	return StatusLine{
+		HttpVersion:  version,
+		StatusCode:   StatusCode(statusCode),
+		ReasonPhrase: reason,
+	}, nil
+}
+
+// readFields parses field lines into h up to and including the empty line
+// that ends them.
+func readFields(br *bufio.Reader, h headers.Headers) error {
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			return nil
+		}
+
+		_, _, err = h.ParseResponse(append(line, '\r', '\n'))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads one CRLF-terminated line and returns it without the CRLF.
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	line, ok := bytes.CutSuffix(line, []byte{'\r', '\n'})
+	if !ok {
+		return nil, errors.New("invalid line: missing CRLF")
+	}
+	return line, nil
+}
+
+// lengthReader reads exactly remaining bytes and reports an unexpected EOF
+// if the connection ends first.
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if err == io.EOF && l.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+type errReader struct {
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	return 0, e.err
+}
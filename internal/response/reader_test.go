@@ -0,0 +1,230 @@
+package response
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestResponseFromReader(t *testing.T) {
+	tests := []struct {
+		name             string
+		data             string
+		method           string
+		expectError      bool
+		expectedStatus   StatusCode
+		expectedReason   string
+		expectedHeaders  map[string]string
+		expectedBody     string
+		expectedTrailers map[string]string
+	}{
+		{
+			name: "Content-Length body",
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"Content-Length: 13\r\n" +
+				"\r\n" +
+				"hello world!\n",
+			method:          "GET",
+			expectedStatus:  StatusOk,
+			expectedReason:  "OK",
+			expectedHeaders: map[string]string{"content-type": "text/plain"},
+			expectedBody:    "hello world!\n",
+		},
+		{
+			name: "Chunked body with extension and trailers",
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5;name=value\r\nhello\r\n" +
+				"7\r\n world!\r\n" +
+				"0\r\n" +
+				"X-Checksum: abc\r\n" +
+				"\r\n",
+			method:           "GET",
+			expectedStatus:   StatusOk,
+			expectedReason:   "OK",
+			expectedBody:     "hello world!",
+			expectedTrailers: map[string]string{"x-checksum": "abc"},
+		},
+		{
+			name: "Chunked wins over Content-Length",
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Content-Length: 100\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"2\r\nhi\r\n0\r\n\r\n",
+			method:         "GET",
+			expectedStatus: StatusOk,
+			expectedReason: "OK",
+			expectedBody:   "hi",
+		},
+		{
+			name: "Body delimited by connection close",
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Connection: close\r\n" +
+				"\r\n" +
+				"until the end",
+			method:         "GET",
+			expectedStatus: StatusOk,
+			expectedReason: "OK",
+			expectedBody:   "until the end",
+		},
+		{
+			name: "Transfer-Encoding without chunked runs until close",
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Transfer-Encoding: gzip\r\n" +
+				"Content-Length: 3\r\n" +
+				"\r\n" +
+				"not framed by the length",
+			method:         "GET",
+			expectedStatus: StatusOk,
+			expectedReason: "OK",
+			expectedBody:   "not framed by the length",
+		},
+		{
+			name: "HEAD response has no body",
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Content-Length: 13\r\n" +
+				"\r\n",
+			method:          "HEAD",
+			expectedStatus:  StatusOk,
+			expectedReason:  "OK",
+			expectedHeaders: map[string]string{"content-length": "13"},
+		},
+		{
+			name:           "204 has no body",
+			data:           "HTTP/1.1 204 No Content\r\n\r\nignored",
+			method:         "DELETE",
+			expectedStatus: StatusNoContent,
+			expectedReason: "No Content",
+		},
+		{
+			name:           "304 has no body",
+			data:           "HTTP/1.1 304 Not Modified\r\nContent-Length: 13\r\n\r\n",
+			method:         "GET",
+			expectedStatus: StatusNotModified,
+			expectedReason: "Not Modified",
+		},
+		{
+			name: "Interim response skipped",
+			data: "HTTP/1.1 100 Continue\r\n\r\n" +
+				"HTTP/1.1 201 Created\r\n" +
+				"Content-Length: 2\r\n" +
+				"\r\n" +
+				"ok",
+			method:         "POST",
+			expectedStatus: 201,
+			expectedReason: "Created",
+			expectedBody:   "ok",
+		},
+		{
+			name:           "Empty reason phrase",
+			data:           "HTTP/1.1 200 \r\nContent-Length: 0\r\n\r\n",
+			method:         "GET",
+			expectedStatus: StatusOk,
+		},
+		{
+			name:        "Invalid status line",
+			data:        "HTTP/1.1 OK\r\n\r\n",
+			method:      "GET",
+			expectError: true,
+		},
+		{
+			name:        "Unsupported version",
+			data:        "HTTP/2 200 OK\r\n\r\n",
+			method:      "GET",
+			expectError: true,
+		},
+		{
+			name:        "Body shorter than Content-Length",
+			data:        "HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\npartial",
+			method:      "GET",
+			expectError: true,
+		},
+		{
+			name:        "Truncated chunked body",
+			data:        "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel",
+			method:      "GET",
+			expectError: true,
+		},
+		{
+			name:        "Invalid chunk size",
+			data:        "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+			method:      "GET",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := ResponseFromReader(strings.NewReader(tc.data), tc.method)
+
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if resp.StatusLine.StatusCode != tc.expectedStatus {
+				t.Errorf("got status %d, want %d", resp.StatusLine.StatusCode, tc.expectedStatus)
+			}
+			if resp.StatusLine.ReasonPhrase != tc.expectedReason {
+				t.Errorf("got reason %q, want %q", resp.StatusLine.ReasonPhrase, tc.expectedReason)
+			}
+			for k, want := range tc.expectedHeaders {
+				if got := resp.Headers[k]; got != want {
+					t.Errorf("header %q: got %q, want %q", k, got, want)
+				}
+			}
+			if string(resp.Body) != tc.expectedBody {
+				t.Errorf("got body %q, want %q", resp.Body, tc.expectedBody)
+			}
+			for k, want := range tc.expectedTrailers {
+				if got := resp.Trailers[k]; got != want {
+					t.Errorf("trailer %q: got %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseFromReaderKeepAlive(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst" +
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nsecond\r\n0\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\n\r\nlast",
+	))
+
+	for i, tc := range []struct {
+		method    string
+		body      string
+		keepAlive bool
+	}{
+		{method: "GET", body: "first", keepAlive: true},
+		{method: "GET", body: "second", keepAlive: true},
+		{method: "HEAD", body: "", keepAlive: true},
+		{method: "GET", body: "last", keepAlive: false},
+	} {
+		resp, err := ResponseFromReader(br, tc.method)
+		if err != nil {
+			t.Fatalf("response %d: unexpected error: %v", i, err)
+		}
+		if string(resp.Body) != tc.body {
+			t.Errorf("response %d: got body %q, want %q", i, resp.Body, tc.body)
+		}
+		if resp.KeepAlive(tc.method) != tc.keepAlive {
+			t.Errorf("response %d: got keep-alive %v, want %v", i, resp.KeepAlive(tc.method), tc.keepAlive)
+		}
+	}
+
+	if br.Buffered() != 0 {
+		t.Errorf("got %d unread bytes, want 0", br.Buffered())
+	}
+}
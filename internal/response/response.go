@@ -3,6 +3,8 @@ package response
 import (
 	"fmt"
 	"io"
+	"maps"
+	"slices"
 	"strconv"
 
 	"github.com/portbound/tcp-to-http/internal/headers"
@@ -11,40 +13,155 @@ import (
 type StatusCode int
 
 const (
-	StatusOk                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
+	StatusContinue             StatusCode = 100
+	StatusSwitchingProtocols   StatusCode = 101
+	StatusOk                   StatusCode = 200
+	StatusCreated              StatusCode = 201
+	StatusNoContent            StatusCode = 204
+	StatusPartialContent       StatusCode = 206
+	StatusMovedPermanently     StatusCode = 301
+	StatusFound                StatusCode = 302
+	StatusSeeOther             StatusCode = 303
+	StatusNotModified          StatusCode = 304
+	StatusTemporaryRedirect    StatusCode = 307
+	StatusPermanentRedirect    StatusCode = 308
+	StatusBadRequest           StatusCode = 400
+	StatusForbidden            StatusCode = 403
+	StatusNotFound             StatusCode = 404
+	StatusMethodNotAllowed     StatusCode = 405
+	StatusNotAcceptable        StatusCode = 406
+	StatusPreconditionFailed   StatusCode = 412
+	StatusContentTooLarge      StatusCode = 413
+	StatusUnsupportedMediaType StatusCode = 415
+	StatusRangeNotSatisfiable  StatusCode = 416
+	StatusExpectationFailed    StatusCode = 417
+	StatusUpgradeRequired      StatusCode = 426
+	StatusHeaderFieldsTooLarge StatusCode = 431
+	StatusInternalServerError  StatusCode = 500
+	StatusNotImplemented       StatusCode = 501
+	StatusBadGateway           StatusCode = 502
+	StatusServiceUnavailable   StatusCode = 503
+	StatusGatewayTimeout       StatusCode = 504
 )
 
-func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
-	reasonPhrase := ""
-
+// StatusText returns the reason phrase for statusCode, such as "Not Found",
+// or "" for a code it does not know.
+func StatusText(statusCode StatusCode) string {
 	switch statusCode {
+	case StatusContinue:
+		return "Continue"
+	case StatusSwitchingProtocols:
+		return "Switching Protocols"
 	case StatusOk:
-		reasonPhrase = "OK"
+		return "OK"
+	case StatusCreated:
+		return "Created"
+	case StatusNoContent:
+		return "No Content"
+	case StatusPartialContent:
+		return "Partial Content"
+	case StatusMovedPermanently:
+		return "Moved Permanently"
+	case StatusFound:
+		return "Found"
+	case StatusSeeOther:
+		return "See Other"
+	case StatusNotModified:
+		return "Not Modified"
+	case StatusTemporaryRedirect:
+		return "Temporary Redirect"
+	case StatusPermanentRedirect:
+		return "Permanent Redirect"
 	case StatusBadRequest:
-		reasonPhrase = "Bad Request"
+		return "Bad Request"
+	case StatusForbidden:
+		return "Forbidden"
+	case StatusNotFound:
+		return "Not Found"
+	case StatusMethodNotAllowed:
+		return "Method Not Allowed"
+	case StatusNotAcceptable:
+		return "Not Acceptable"
+	case StatusPreconditionFailed:
+		return "Precondition Failed"
+	case StatusContentTooLarge:
+		return "Content Too Large"
+	case StatusUnsupportedMediaType:
+		return "Unsupported Media Type"
+	case StatusRangeNotSatisfiable:
+		return "Range Not Satisfiable"
+	case StatusExpectationFailed:
+		return "Expectation Failed"
+	case StatusUpgradeRequired:
+		return "Upgrade Required"
+	case StatusHeaderFieldsTooLarge:
+		return "Request Header Fields Too Large"
 	case StatusInternalServerError:
-		reasonPhrase = "Internal Server Error"
+		return "Internal Server Error"
+	case StatusNotImplemented:
+		return "Not Implemented"
+	case StatusBadGateway:
+		return "Bad Gateway"
+	case StatusServiceUnavailable:
+		return "Service Unavailable"
+	case StatusGatewayTimeout:
+		return "Gateway Timeout"
 	}
+	return ""
+}
+
+func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
+	_, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode))
+	return err
+}
 
-	_, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase)
+// WriteContinue sends the interim 100 Continue response a client waits for
+// after sending "Expect: 100-continue".
+func WriteContinue(w io.Writer) error {
+	if err := WriteStatusLine(w, StatusContinue); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte("\r\n"))
 	return err
 }
 
 func GetDefaultHeaders(contentLen int) headers.Headers {
-	headers := make(map[string]string)
-	headers["Content-Length"] = strconv.Itoa(contentLen)
-	headers["Connection"] = "close"
-	headers["Content-Type"] = "text/plain"
-	return headers
+	h := make(headers.Headers)
+	h.Set("Content-Length", strconv.Itoa(contentLen))
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/plain")
+	return h
+}
+
+// WriteHeaders writes each field on its own line, sorted by name and with
+// canonical capitalization. Fields that cannot be combined, like
+// Set-Cookie, get a line per value. A value containing CR, LF or NUL,
+// which can only get in by assigning to the map directly, is an error and
+// nothing is written.
+func WriteHeaders(w io.Writer, h headers.Headers) error {
+	if err := checkHeaders(h); err != nil {
+		return err
+	}
+	keys := slices.Sorted(maps.Keys(h))
+	for _, key := range keys {
+		name := headers.CanonicalKey(key)
+		for _, value := range h.Values(key) {
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func WriteHeaders(w io.Writer, headers headers.Headers) error {
-	for key, value := range headers {
-		_, err := fmt.Fprintf(w, "%s: %s\r\n", key, value)
-		if err != nil {
-			return err
+// checkHeaders returns an error for any value that would break out of its
+// field line.
+func checkHeaders(h headers.Headers) error {
+	for key := range h {
+		for _, value := range h.Values(key) {
+			if !headers.ValidValue(value) {
+				return fmt.Errorf("%w for %s: %q", headers.ErrInvalidValue, key, value)
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,172 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidEvent is returned for an event field that would break the
+// event stream framing, such as an ID containing a newline.
+var ErrInvalidEvent = errors.New("invalid server-sent event")
+
+// Event is one server-sent event. Empty fields are left out.
+type Event struct {
+	// ID is what the client sends back as Last-Event-ID when it
+	// reconnects.
+	ID string
+	// Event is the event type, which defaults to "message" on the client.
+	Event string
+	// Data may span several lines, each sent as its own data field.
+	Data string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// EventStream sends server-sent events, as specified by the HTML Living
+// Standard, on a response. Each event is flushed as soon as it is sent.
+// Its methods may be called from several goroutines.
+type EventStream struct {
+	mu   sync.Mutex
+	w    *Writer
+	err  error
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewEventStream starts a text/event-stream response on w and sends its
+// head. The response is marked no-transform, so Compression leaves it
+// alone, and proxies such as nginx are asked not to buffer it. A heartbeat
+// above zero sends a comment at that interval, which keeps idle
+// connections open through proxies and notices clients that have gone.
+//
+// The stream is closed when w is, if it has not been already, so nothing
+// is sent after the end of the response.
+func NewEventStream(w *Writer, heartbeat time.Duration) (*EventStream, error) {
+	h := w.Headers()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache, no-transform")
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	es := &EventStream{w: w, stop: make(chan struct{}), done: make(chan struct{})}
+	w.onEnd = append(w.onEnd, func() { es.Close() })
+	if heartbeat > 0 {
+		go es.heartbeat(heartbeat)
+	}
+	return es, nil
+}
+
+// Send writes e and flushes it to the client.
+func (es *EventStream) Send(e Event) error {
+	var b strings.Builder
+	if err := formatEvent(&b, e); err != nil {
+		return err
+	}
+	return es.write(b.String())
+}
+
+// Comment sends text as a comment line, which clients ignore.
+func (es *EventStream) Comment(text string) error {
+	if strings.ContainsAny(text, "\r\n") {
+		return fmt.Errorf("%w: newline in comment", ErrInvalidEvent)
+	}
+	return es.write(": " + text + "\n\n")
+}
+
+// Done is closed once the stream is closed or a write to the client fails,
+// usually because it disconnected.
+func (es *EventStream) Done() <-chan struct{} {
+	return es.done
+}
+
+// Close stops the heartbeat. Events cannot be sent afterwards; the response
+// ends when the handler returns. Once Close returns, the stream no longer
+// touches the Writer.
+func (es *EventStream) Close() error {
+	es.once.Do(func() { close(es.stop) })
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.fail(ErrWriterClosed)
+	return nil
+}
+
+func (es *EventStream) write(s string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.err != nil {
+		return es.err
+	}
+	if _, err := io.WriteString(es.w, s); err != nil {
+		es.fail(err)
+		return err
+	}
+	if err := es.w.Flush(); err != nil {
+		es.fail(err)
+		return err
+	}
+	return nil
+}
+
+// fail records the stream's first error and closes done. es.mu must be
+// held.
+func (es *EventStream) fail(err error) {
+	if es.err == nil {
+		es.err = err
+		close(es.done)
+	}
+}
+
+func (es *EventStream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-es.stop:
+			return
+		case <-ticker.C:
+			if es.write(":\n\n") != nil {
+				return
+			}
+		}
+	}
+}
+
+// formatEvent writes e's fields followed by the blank line that ends an
+// event. Line breaks in Data, whether CRLF, LF or CR, start a new data
+// field; in any other field they are an error.
+func formatEvent(b *strings.Builder, e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return fmt.Errorf("%w: invalid ID %q", ErrInvalidEvent, e.ID)
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return fmt.Errorf("%w: invalid event type %q", ErrInvalidEvent, e.Event)
+	}
+
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	if e.Data != "" {
+		data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+		for line := range strings.SplitSeq(data, "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return nil
+}
@@ -0,0 +1,147 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFormatEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		event     Event
+		expected  string
+		expectErr bool
+	}{
+		{name: "Data only", event: Event{Data: "hello"}, expected: "data: hello\n\n"},
+		{
+			name:     "All fields",
+			event:    Event{ID: "42", Event: "log", Data: "line 1", Retry: 3 * time.Second},
+			expected: "event: log\nid: 42\nretry: 3000\ndata: line 1\n\n",
+		},
+		{name: "Multi-line data", event: Event{Data: "a\nb\r\nc\rd"}, expected: "data: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{name: "Trailing newline", event: Event{Data: "a\n"}, expected: "data: a\ndata: \n\n"},
+		{name: "Newline in ID", event: Event{ID: "1\n2", Data: "x"}, expectErr: true},
+		{name: "NUL in ID", event: Event{ID: "1\x002", Data: "x"}, expectErr: true},
+		{name: "Newline in type", event: Event{Event: "a\rb", Data: "x"}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var b strings.Builder
+			err := formatEvent(&b, tc.event)
+			if tc.expectErr {
+				if !errors.Is(err, ErrInvalidEvent) {
+					t.Fatalf("got error %v, want %v", err, ErrInvalidEvent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := b.String(); got != tc.expected {
+				t.Errorf("got %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to read while a heartbeat writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestEventStream(t *testing.T) {
+	buf := &syncBuffer{}
+	w := NewWriter(buf)
+	w.Headers().Set("Content-Length", "100")
+
+	es, err := NewEventStream(w, 5*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	head := buf.String()
+	for _, want := range []string{"Content-Type: text/event-stream\r\n", "Cache-Control: no-cache, no-transform\r\n", "Transfer-Encoding: chunked\r\n"} {
+		if !strings.Contains(head, want) {
+			t.Errorf("got head %q, want it to contain %q", head, want)
+		}
+	}
+	if strings.Contains(head, "Content-Length") {
+		t.Errorf("got head %q, want no Content-Length", head)
+	}
+
+	if err := es.Send(Event{ID: "1", Data: "build started"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := buf.String(); !strings.Contains(got, "id: 1\ndata: build started\n\n") {
+		t.Errorf("event not flushed: %q", got)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(buf.String(), ":\n\n") {
+		if time.Now().After(deadline) {
+			t.Fatal("no heartbeat sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := es.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-es.Done():
+	default:
+		t.Error("Done not closed after Close")
+	}
+	if err := es.Send(Event{Data: "late"}); err == nil {
+		t.Error("expected an error sending after Close")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(buf.String(), "0\r\n\r\n") {
+		t.Errorf("got %q, want the chunked body ended", buf.String())
+	}
+}
+
+func TestEventStreamEndsWithWriter(t *testing.T) {
+	buf := &syncBuffer{}
+	w := NewWriter(buf)
+	es, err := NewEventStream(w, time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// The handler returned without closing the stream; ending the response
+	// must stop the heartbeat before the final chunk goes out.
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-es.Done():
+	default:
+		t.Error("Done not closed after the Writer was")
+	}
+	ended := buf.String()
+	time.Sleep(5 * time.Millisecond)
+	if got := buf.String(); got != ended || !strings.HasSuffix(got, "0\r\n\r\n") {
+		t.Errorf("got %q after the response ended", strings.TrimPrefix(got, ended))
+	}
+}
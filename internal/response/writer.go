@@ -0,0 +1,437 @@
+package response
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+)
+
+// TimeFormat is the format of dates in HTTP fields such as Last-Modified.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// ErrInvalidETag is returned by SetETag and SetWeakETag for a tag holding
+// a quote, a space or a control character, none of which RFC 9110 allows.
+var ErrInvalidETag = errors.New("invalid entity tag")
+
+// ErrWriterClosed is returned for writes after the response has ended.
+var ErrWriterClosed = errors.New("response already finished")
+
+// ErrHijacked is returned for writes after the connection has been taken
+// over with Hijack, and ErrNotHijackable when the Writer has no connection
+// to hand over.
+var (
+	ErrHijacked      = errors.New("connection has been hijacked")
+	ErrNotHijackable = errors.New("connection cannot be hijacked")
+)
+
+// Writer is what a handler builds its response with. Until the head is
+// sent, the status code and headers can still change and body bytes are
+// buffered, so a short response goes out with a Content-Length. Flush sends
+// the head and anything buffered; from then on the body streams, chunked
+// unless a Content-Length was set.
+type Writer struct {
+	conn       io.Writer
+	statusCode StatusCode
+	headers    headers.Headers
+	body       bytes.Buffer
+	wroteHead  bool
+	chunked    bool
+	closed     bool
+
+	encoder Encoder
+	encoded io.WriteCloser
+
+	hijacker Hijacker
+	hijacked bool
+	omitBody bool
+
+	// onEnd holds what must stop before the response ends, such as the
+	// heartbeat of an EventStream.
+	onEnd []func()
+}
+
+// A Hijacker hands over the connection a response is being written to,
+// along with any bytes already read from it that the request did not use.
+// The server installs one on the Writers it creates.
+type Hijacker func() (net.Conn, []byte, error)
+
+// An Encoder transforms a response body, typically to apply a content
+// coding. It runs once, just before the head is sent: buffered is the body
+// written so far and complete reports whether that is all of it. It may
+// change the status and headers through w. Returning nil sends the body
+// unchanged; otherwise every body byte goes through the returned writer,
+// which writes to dst and is closed when the response ends.
+//
+// The Writer takes care of Content-Length, since an encoded body's length
+// differs from the one the handler declared.
+type Encoder func(w *Writer, dst io.Writer, buffered []byte, complete bool) io.WriteCloser
+
+// flusher is implemented by encoders, such as gzip.Writer, that hold back
+// output until told to flush it.
+type flusher interface {
+	Flush() error
+}
+
+// NewWriter returns a Writer for a 200 response with the server's default
+// headers, writing to conn.
+func NewWriter(conn io.Writer) *Writer {
+	h := GetDefaultHeaders(0)
+	h.Del("Content-Length")
+	return &Writer{
+		conn:       conn,
+		statusCode: StatusOk,
+		headers:    h,
+	}
+}
+
+// Headers returns the response headers. Changes made after the head has
+// been sent have no effect.
+func (w *Writer) Headers() headers.Headers {
+	return w.headers
+}
+
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// SetStatusCode sets the status sent with the head. It is ignored once the
+// head has been sent.
+func (w *Writer) SetStatusCode(statusCode StatusCode) {
+	if w.wroteHead {
+		return
+	}
+	w.statusCode = statusCode
+}
+
+// SetETag sets a strong entity tag, which promises the body is byte for byte
+// the same whenever the tag is. tag is quoted for the header.
+func (w *Writer) SetETag(tag string) error {
+	if !validETag(tag) {
+		return fmt.Errorf("%w: %q", ErrInvalidETag, tag)
+	}
+	w.headers["etag"] = `"` + tag + `"`
+	return nil
+}
+
+// SetWeakETag sets a weak entity tag, for bodies that are equivalent but may
+// differ in their bytes, such as ones with an embedded timestamp.
+func (w *Writer) SetWeakETag(tag string) error {
+	if !validETag(tag) {
+		return fmt.Errorf("%w: %q", ErrInvalidETag, tag)
+	}
+	w.headers["etag"] = `W/"` + tag + `"`
+	return nil
+}
+
+// validETag reports whether tag is made of etagc characters: visible ASCII
+// other than the quote, and obs-text.
+func validETag(tag string) bool {
+	for i := 0; i < len(tag); i++ {
+		if ch := tag[i]; ch <= 0x20 || ch == '"' || ch == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// SetLastModified sets Last-Modified to t, which is sent at second
+// precision in UTC. A formatted date is always a valid value, so unlike
+// Headers.Set it cannot fail.
+func (w *Writer) SetLastModified(t time.Time) {
+	w.headers["last-modified"] = t.UTC().Format(TimeFormat)
+}
+
+// ETagOf returns a tag derived from the contents of body, for handlers that
+// build a body before deciding whether to send it.
+func ETagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:16])
+}
+
+// SetEncoder installs e to run when the head is sent. It is ignored once
+// the head has been sent.
+func (w *Writer) SetEncoder(e Encoder) {
+	if w.wroteHead {
+		return
+	}
+	w.encoder = e
+}
+
+// representationHeaders describe the body rather than the response, so
+// Discard drops them along with it.
+var representationHeaders = []string{
+	"content-encoding",
+	"content-length",
+	"content-range",
+	"content-type",
+	"etag",
+	"last-modified",
+}
+
+// Discard drops the buffered body and the headers describing it, so a
+// different body, such as an error page, can take its place. Other headers
+// and any encoder are kept. It does nothing once the head has been sent.
+func (w *Writer) Discard() {
+	if w.wroteHead {
+		return
+	}
+	w.body.Reset()
+	for _, name := range representationHeaders {
+		delete(w.headers, name)
+	}
+}
+
+// SetHijacker installs h for Hijack to call.
+func (w *Writer) SetHijacker(h Hijacker) {
+	w.hijacker = h
+}
+
+// Hijack takes over the connection, for protocols such as WebSocket or a
+// CONNECT tunnel that speak something other than HTTP after the request.
+// buffered holds bytes the client has already sent past the request, which
+// should be read before anything from conn.
+//
+// What has been flushed stays sent; an unsent head and buffered body are
+// dropped, so a handler that needs a response before switching, such as
+// 101 Switching Protocols, must Flush first; a 1xx head goes out without
+// the default Connection: close, so set Connection: Upgrade where the
+// protocol asks for it. Afterwards the Writer accepts
+// no more writes, and the server neither finishes the response nor closes
+// conn: both are up to the caller.
+func (w *Writer) Hijack() (conn net.Conn, buffered []byte, err error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.closed {
+		return nil, nil, ErrWriterClosed
+	}
+	if w.hijacker == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	if conn, buffered, err = w.hijacker(); err != nil {
+		return nil, nil, err
+	}
+	w.end()
+	w.hijacked = true
+	w.closed = true
+	w.body.Reset()
+	return conn, buffered, nil
+}
+
+// Hijacked reports whether Hijack has taken over the connection.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+// OmitBody makes the response a head alone, as a HEAD request needs, while
+// the head still describes the body written: a buffered body sets the
+// Content-Length as usual but is not sent.
+func (w *Writer) OmitBody() {
+	w.omitBody = true
+}
+
+// HeadWritten reports whether the status line and headers have been sent.
+func (w *Writer) HeadWritten() bool {
+	return w.wroteHead
+}
+
+// Write adds p to the body. Before the head is sent it is buffered; after,
+// it goes straight to the connection.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+	if !w.wroteHead {
+		return w.body.Write(p)
+	}
+	if w.encoded != nil {
+		return w.encoded.Write(p)
+	}
+	return w.writeBody(p)
+}
+
+// Flush sends the head if it has not been sent yet, followed by any
+// buffered body bytes. Without a Content-Length header the body switches to
+// chunked transfer coding.
+func (w *Writer) Flush() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if w.closed {
+		return ErrWriterClosed
+	}
+	if !w.wroteHead {
+		if e := w.encoder; e != nil {
+			w.encoder = nil
+			if enc := e(w, rawBody{w}, w.body.Bytes(), false); enc != nil {
+				w.encoded = enc
+				w.headers.Del("Content-Length")
+			}
+		}
+		if _, ok := w.headers["content-length"]; !ok && w.bodyAllowed() && !w.omitBody {
+			w.chunked = true
+			w.headers.Set("Transfer-Encoding", "chunked")
+		}
+		if err := w.writeHead(); err != nil {
+			return err
+		}
+	}
+
+	if w.body.Len() > 0 {
+		var err error
+		if w.encoded != nil {
+			_, err = w.encoded.Write(w.body.Bytes())
+		} else {
+			_, err = w.writeBody(w.body.Bytes())
+		}
+		w.body.Reset()
+		if err != nil {
+			return err
+		}
+	}
+
+	if f, ok := w.encoded.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close finishes the response. A response that was never flushed is sent
+// whole with a Content-Length; a chunked one gets its final chunk. It is
+// called by the server once the handler returns.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.end()
+
+	if !w.wroteHead {
+		if err := w.encodeBuffered(); err != nil {
+			return err
+		}
+		if _, ok := w.headers["content-length"]; !ok && w.bodyAllowed() {
+			w.headers.Set("Content-Length", strconv.Itoa(w.body.Len()))
+		}
+		if w.omitBody {
+			w.body.Reset()
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	w.closed = true
+	if w.encoded != nil {
+		if err := w.encoded.Close(); err != nil {
+			return err
+		}
+	}
+	if w.chunked {
+		_, err := io.WriteString(w.conn, "0\r\n\r\n")
+		return err
+	}
+	return nil
+}
+
+// end runs and clears the functions registered in onEnd.
+func (w *Writer) end() {
+	for _, f := range w.onEnd {
+		f()
+	}
+	w.onEnd = nil
+}
+
+// encodeBuffered runs the encoder over a body that was never flushed, so
+// the encoded result can go out with its own Content-Length.
+func (w *Writer) encodeBuffered() error {
+	e := w.encoder
+	if e == nil {
+		return nil
+	}
+	w.encoder = nil
+
+	var encoded bytes.Buffer
+	enc := e(w, &encoded, w.body.Bytes(), true)
+	if enc == nil {
+		return nil
+	}
+	if _, err := enc.Write(w.body.Bytes()); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	w.headers.Del("Content-Length")
+	w.body.Reset()
+	w.body.Write(encoded.Bytes())
+	return nil
+}
+
+func (w *Writer) writeHead() error {
+	w.wroteHead = true
+	// Checked before the status line, so a bad value sends nothing at all.
+	if err := checkHeaders(w.headers); err != nil {
+		return err
+	}
+	// An interim response, such as 101 Switching Protocols, leaves the
+	// connection open, so the default Connection: close would contradict it.
+	if w.statusCode >= 100 && w.statusCode < 200 && strings.EqualFold(w.headers.Get("Connection"), "close") {
+		w.headers.Del("Connection")
+	}
+	if err := WriteStatusLine(w.conn, w.statusCode); err != nil {
+		return err
+	}
+	if err := WriteHeaders(w.conn, w.headers); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.conn, "\r\n")
+	return err
+}
+
+func (w *Writer) writeBody(p []byte) (int, error) {
+	if len(p) == 0 || !w.bodyAllowed() || w.omitBody {
+		return len(p), nil
+	}
+	if !w.chunked {
+		return w.conn.Write(p)
+	}
+
+	if _, err := fmt.Fprintf(w.conn, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := w.conn.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(w.conn, "\r\n")
+	return n, err
+}
+
+// rawBody writes encoder output to the connection as body bytes.
+type rawBody struct {
+	w *Writer
+}
+
+func (b rawBody) Write(p []byte) (int, error) {
+	return b.w.writeBody(p)
+}
+
+// bodyAllowed reports whether the status permits a body; 1xx, 204 and 304
+// responses never carry one.
+func (w *Writer) bodyAllowed() bool {
+	code := w.statusCode
+	return !(code >= 100 && code < 200) && code != StatusNoContent && code != StatusNotModified
+}
@@ -0,0 +1,317 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+)
+
+// shout is an Encoder that upper-cases the body and ends it with "!".
+func shout(w *Writer, dst io.Writer, buffered []byte, complete bool) io.WriteCloser {
+	w.Headers().Set("Content-Encoding", "shout")
+	return shoutWriter{dst}
+}
+
+type shoutWriter struct {
+	dst io.Writer
+}
+
+func (s shoutWriter) Write(p []byte) (int, error) {
+	return s.dst.Write(bytes.ToUpper(p))
+}
+
+func (s shoutWriter) Close() error {
+	_, err := io.WriteString(s.dst, "!")
+	return err
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    func(w *Writer)
+		expected string
+	}{
+		{
+			name: "Buffered body gets Content-Length",
+			write: func(w *Writer) {
+				fmt.Fprint(w, "hello ")
+				fmt.Fprint(w, "world")
+			},
+			expected: "HTTP/1.1 200 OK\r\n" +
+				"Connection: close\r\n" +
+				"Content-Length: 11\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"\r\n" +
+				"hello world",
+		},
+		{
+			name: "Status and headers set before writing",
+			write: func(w *Writer) {
+				w.SetStatusCode(StatusNotFound)
+				w.Headers().Set("Content-Type", "text/html")
+				w.Headers().Set("X-Trace", "abc")
+				fmt.Fprint(w, "<p>missing</p>")
+			},
+			expected: "HTTP/1.1 404 Not Found\r\n" +
+				"Connection: close\r\n" +
+				"Content-Length: 14\r\n" +
+				"Content-Type: text/html\r\n" +
+				"X-Trace: abc\r\n" +
+				"\r\n" +
+				"<p>missing</p>",
+		},
+		{
+			name: "Flush switches to chunked",
+			write: func(w *Writer) {
+				fmt.Fprint(w, "first")
+				w.Flush()
+				w.SetStatusCode(StatusInternalServerError)
+				fmt.Fprint(w, "second!")
+			},
+			expected: "HTTP/1.1 200 OK\r\n" +
+				"Connection: close\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5\r\nfirst\r\n" +
+				"7\r\nsecond!\r\n" +
+				"0\r\n\r\n",
+		},
+		{
+			name: "Flush with Content-Length streams raw",
+			write: func(w *Writer) {
+				w.Headers().Set("Content-Length", "6")
+				w.Flush()
+				fmt.Fprint(w, "abc")
+				fmt.Fprint(w, "def")
+			},
+			expected: "HTTP/1.1 200 OK\r\n" +
+				"Connection: close\r\n" +
+				"Content-Length: 6\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"\r\n" +
+				"abcdef",
+		},
+		{
+			name: "Validators",
+			write: func(w *Writer) {
+				w.SetETag("abc")
+				w.SetLastModified(time.Date(2024, 3, 1, 13, 4, 5, 999, time.FixedZone("CET", 3600)))
+			},
+			expected: "HTTP/1.1 200 OK\r\n" +
+				"Connection: close\r\n" +
+				"Content-Length: 0\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"Etag: \"abc\"\r\n" +
+				"Last-Modified: Fri, 01 Mar 2024 12:04:05 GMT\r\n" +
+				"\r\n",
+		},
+		{
+			name: "Weak ETag",
+			write: func(w *Writer) {
+				w.SetWeakETag("abc")
+			},
+			expected: "HTTP/1.1 200 OK\r\n" +
+				"Connection: close\r\n" +
+				"Content-Length: 0\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"Etag: W/\"abc\"\r\n" +
+				"\r\n",
+		},
+		{
+			name: "Encoder over buffered body",
+			write: func(w *Writer) {
+				w.SetEncoder(shout)
+				w.Headers().Set("Content-Length", "5")
+				fmt.Fprint(w, "hello")
+			},
+			expected: "HTTP/1.1 200 OK\r\n" +
+				"Connection: close\r\n" +
+				"Content-Encoding: shout\r\n" +
+				"Content-Length: 6\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"\r\n" +
+				"HELLO!",
+		},
+		{
+			name: "Encoder over streamed body",
+			write: func(w *Writer) {
+				w.SetEncoder(shout)
+				w.Headers().Set("Content-Length", "10")
+				fmt.Fprint(w, "hello")
+				w.Flush()
+				fmt.Fprint(w, "world")
+			},
+			expected: "HTTP/1.1 200 OK\r\n" +
+				"Connection: close\r\n" +
+				"Content-Encoding: shout\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5\r\nHELLO\r\n" +
+				"5\r\nWORLD\r\n" +
+				"1\r\n!\r\n" +
+				"0\r\n\r\n",
+		},
+		{
+			name: "Encoder declining",
+			write: func(w *Writer) {
+				w.SetEncoder(func(w *Writer, dst io.Writer, buffered []byte, complete bool) io.WriteCloser {
+					return nil
+				})
+				fmt.Fprint(w, "hello")
+			},
+			expected: "HTTP/1.1 200 OK\r\n" +
+				"Connection: close\r\n" +
+				"Content-Length: 5\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"\r\n" +
+				"hello",
+		},
+		{
+			name: "JSON",
+			write: func(w *Writer) {
+				w.WriteJSON(StatusCreated, map[string]any{"id": 7, "name": "a&b"})
+			},
+			expected: "HTTP/1.1 201 Created\r\n" +
+				"Connection: close\r\n" +
+				"Content-Length: 27\r\n" +
+				"Content-Type: application/json\r\n" +
+				"\r\n" +
+				"{\"id\":7,\"name\":\"a\\u0026b\"}\n",
+		},
+		{
+			name: "No Content drops the body",
+			write: func(w *Writer) {
+				w.SetStatusCode(StatusNoContent)
+				fmt.Fprint(w, "ignored")
+			},
+			expected: "HTTP/1.1 204 No Content\r\n" +
+				"Connection: close\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"\r\n",
+		},
+		{
+			name: "Interim status drops Connection: close",
+			write: func(w *Writer) {
+				w.SetStatusCode(StatusSwitchingProtocols)
+				w.Headers().Set("Upgrade", "example")
+			},
+			expected: "HTTP/1.1 101 Switching Protocols\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"Upgrade: example\r\n" +
+				"\r\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			w := NewWriter(buf)
+			tc.write(w)
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if buf.String() != tc.expected {
+				t.Errorf("got\n%q\nwant\n%q", buf.String(), tc.expected)
+			}
+
+			if _, err := w.Write([]byte("late")); err != ErrWriterClosed {
+				t.Errorf("write after close: got %v, want %v", err, ErrWriterClosed)
+			}
+		})
+	}
+}
+
+func TestWriterHijack(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	if _, _, err := w.Hijack(); err != ErrNotHijackable {
+		t.Fatalf("got %v, want %v", err, ErrNotHijackable)
+	}
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	w.SetHijacker(func() (net.Conn, []byte, error) {
+		return server, []byte("early"), nil
+	})
+
+	w.SetStatusCode(StatusSwitchingProtocols)
+	w.Headers().Set("Connection", "Upgrade")
+	w.Headers().Set("Upgrade", "example")
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fmt.Fprint(w, "dropped")
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conn != server || string(buffered) != "early" {
+		t.Errorf("got %v and %q, want the hijacker's connection and bytes", conn, buffered)
+	}
+	if !w.Hijacked() {
+		t.Error("expected Hijacked to report true")
+	}
+
+	if _, err := w.Write([]byte("late")); err != ErrHijacked {
+		t.Errorf("write after hijack: got %v, want %v", err, ErrHijacked)
+	}
+	if err := w.Flush(); err != ErrHijacked {
+		t.Errorf("flush after hijack: got %v, want %v", err, ErrHijacked)
+	}
+	if _, _, err := w.Hijack(); err != ErrHijacked {
+		t.Errorf("second hijack: got %v, want %v", err, ErrHijacked)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Upgrade: example\r\n" +
+		"\r\n"
+	if buf.String() != want {
+		t.Errorf("got\n%q\nwant\n%q", buf.String(), want)
+	}
+}
+
+func TestWriterRejectsLineBreaks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	// Assigning to the map skips the check in Set.
+	w.Headers()["location"] = "/next\r\nSet-Cookie: admin=1"
+	fmt.Fprint(w, "body")
+
+	if err := w.Close(); !errors.Is(err, headers.ErrInvalidValue) {
+		t.Fatalf("got %v, want %v", err, headers.ErrInvalidValue)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got %q written, want nothing", buf.String())
+	}
+}
+
+func TestWriterRejectsInvalidETag(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	for _, tag := range []string{`a"b`, "a b", "a\r\nSet-Cookie: x=1"} {
+		if err := w.SetETag(tag); !errors.Is(err, ErrInvalidETag) {
+			t.Errorf("SetETag %q: got %v, want %v", tag, err, ErrInvalidETag)
+		}
+		if err := w.SetWeakETag(tag); !errors.Is(err, ErrInvalidETag) {
+			t.Errorf("SetWeakETag %q: got %v, want %v", tag, err, ErrInvalidETag)
+		}
+	}
+	if got := w.Headers().Get("ETag"); got != "" {
+		t.Errorf("got ETag %q, want none", got)
+	}
+}
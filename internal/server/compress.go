@@ -0,0 +1,271 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+const defaultMinCompressSize = 1024
+
+// defaultCompressibleTypes are the media types compressed when Compression
+// has no Types. A trailing "/" matches every subtype, and any "+json" or
+// "+xml" structured syntax suffix is always compressed.
+var defaultCompressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/wasm",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// codings are the content codings Compression can apply, in the order it
+// prefers them when a client weights them equally.
+var codings = []string{"gzip", "deflate"}
+
+// Compression is middleware that compresses response bodies with gzip or
+// deflate, whichever the client's Accept-Encoding weights highest. Only
+// bodies of a compressible type and at least MinSize bytes are compressed,
+// and responses that could be compressed carry "Vary: Accept-Encoding".
+// Both buffered and flushed responses are handled; a flushed one loses its
+// Content-Length and is sent chunked.
+type Compression struct {
+	// MinSize is the smallest body worth compressing. Zero means 1024.
+	// A streamed body without a Content-Length is always compressed.
+	MinSize int
+	// Types lists the media types to compress, where "text/" matches every
+	// text type. Nil means a default set of text, JSON, XML and JavaScript.
+	Types []string
+	// Level is the compression level from compress/flate. Zero means
+	// flate.DefaultCompression.
+	Level int
+
+	gzipWriters sync.Pool
+	zlibWriters sync.Pool
+}
+
+// Compress wraps next in a Compression with the default settings.
+func Compress(next Handler) Handler {
+	return (&Compression{}).Wrap(next)
+}
+
+// Wrap returns a Handler that compresses the responses of next.
+func (c *Compression) Wrap(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		coding := negotiateEncoding(req.Headers.Get("Accept-Encoding"))
+		head := req.RequestLine.Method == "HEAD"
+
+		w.SetEncoder(func(w *response.Writer, dst io.Writer, buffered []byte, complete bool) io.WriteCloser {
+			if !c.compressible(w) {
+				return nil
+			}
+			addVary(w.Headers(), "Accept-Encoding")
+
+			// A HEAD response has no body to measure, so it is described as
+			// the uncompressed body rather than guessed at.
+			if coding == "" || head {
+				return nil
+			}
+
+			size := -1
+			if complete {
+				size = len(buffered)
+			} else if declared, ok := w.Headers()["content-length"]; ok {
+				if n, err := strconv.Atoi(declared); err == nil {
+					size = n
+				}
+			}
+			if size >= 0 && size < c.minSize() {
+				return nil
+			}
+
+			enc := c.writer(coding, dst)
+			if enc == nil {
+				return nil
+			}
+			w.Headers().Set("Content-Encoding", coding)
+			// The compressed bytes differ from the ones a strong ETag
+			// promised, though they still represent the same content.
+			if etag := w.Headers().Get("ETag"); strings.HasPrefix(etag, `"`) {
+				w.Headers().Set("ETag", "W/"+etag)
+			}
+			return enc
+		})
+
+		return next(w, req)
+	}
+}
+
+func (c *Compression) minSize() int {
+	if c.MinSize == 0 {
+		return defaultMinCompressSize
+	}
+	return c.MinSize
+}
+
+// compressible reports whether w's response is one Compression applies to.
+// Partial content is never compressed, since its ranges index the
+// uncompressed body.
+func (c *Compression) compressible(w *response.Writer) bool {
+	switch code := w.StatusCode(); {
+	case code < 200, code == response.StatusNoContent, code == response.StatusPartialContent, code == response.StatusNotModified:
+		return false
+	}
+
+	h := w.Headers()
+	if h.Get("Content-Encoding") != "" || strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	if strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+
+	types := c.Types
+	if types == nil {
+		types = defaultCompressibleTypes
+	}
+	for _, t := range types {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// writer returns a pooled compressor for coding writing to dst, or nil if
+// Level is not a valid level. HTTP's "deflate" is the zlib format wrapped
+// around a compress/flate stream, not a bare flate stream.
+func (c *Compression) writer(coding string, dst io.Writer) io.WriteCloser {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	switch coding {
+	case "gzip":
+		if zw, ok := c.gzipWriters.Get().(*gzip.Writer); ok {
+			zw.Reset(dst)
+			return &pooledWriter{compressor: zw, pool: &c.gzipWriters}
+		}
+		zw, err := gzip.NewWriterLevel(dst, level)
+		if err != nil {
+			return nil
+		}
+		return &pooledWriter{compressor: zw, pool: &c.gzipWriters}
+	case "deflate":
+		if zw, ok := c.zlibWriters.Get().(*zlib.Writer); ok {
+			zw.Reset(dst)
+			return &pooledWriter{compressor: zw, pool: &c.zlibWriters}
+		}
+		zw, err := zlib.NewWriterLevel(dst, level)
+		if err != nil {
+			return nil
+		}
+		return &pooledWriter{compressor: zw, pool: &c.zlibWriters}
+	}
+	return nil
+}
+
+// compressor is the part of gzip.Writer and zlib.Writer that Compression
+// uses.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// pooledWriter returns its compressor to the pool once the body is done.
+type pooledWriter struct {
+	compressor
+	pool *sync.Pool
+}
+
+func (p *pooledWriter) Close() error {
+	err := p.compressor.Close()
+	p.pool.Put(p.compressor)
+	return err
+}
+
+// DecompressBody returns a Handler that decodes gzip and deflate request
+// bodies with Request.DecodeBody before calling next, so next sees the body
+// as if it had been sent plain. Bodies larger than limit bytes, as sent or
+// once decoded, get a 413, and other content codings get a 415.
+func DecompressBody(limit int, next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		if err := req.DecodeBody(limit); err != nil {
+			switch {
+			case errors.Is(err, request.ErrBodyTooLarge):
+				return &HandlerError{StatusCode: response.StatusContentTooLarge, Message: err.Error()}
+			case errors.Is(err, request.ErrUnsupportedEncoding):
+				// RFC 9110 section 15.5.16: list the codings that would work.
+				w.Headers().Set("Accept-Encoding", strings.Join(codings, ", "))
+				return &HandlerError{StatusCode: response.StatusUnsupportedMediaType, Message: err.Error()}
+			}
+			return &HandlerError{StatusCode: response.StatusBadRequest, Message: err.Error()}
+		}
+		return next(w, req)
+	}
+}
+
+// negotiateEncoding picks the coding from codings that accept weights
+// highest, or "" when the client accepts none of them. A coding not listed
+// takes the weight of "*", and "x-gzip" counts as "gzip".
+func negotiateEncoding(accept string) string {
+	if accept == "" {
+		return ""
+	}
+
+	list := headers.ParseQualityList(accept)
+	best, bestQ := "", 0.0
+	for _, coding := range codings {
+		q, found := 0.0, false
+		wildcard := -1.0
+		for _, qv := range list {
+			name := strings.ToLower(qv.Value)
+			if name == coding || (coding == "gzip" && name == "x-gzip") {
+				q, found = qv.Q, true
+				break
+			}
+			if name == "*" {
+				wildcard = qv.Q
+			}
+		}
+		if !found && wildcard >= 0 {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// addVary adds field to h's Vary header unless it is already listed.
+func addVary(h headers.Headers, field string) {
+	vary := h.Get("Vary")
+	for name := range strings.SplitSeq(vary, ",") {
+		name = strings.TrimSpace(name)
+		if name == "*" || strings.EqualFold(name, field) {
+			return
+		}
+	}
+	if vary == "" {
+		h.Set("Vary", field)
+		return
+	}
+	h.Set("Vary", vary+", "+field)
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat(`{"status":"ok","checks":["db","cache","queue"]}`, 100)
+
+	respond := func(contentType, body string) Handler {
+		return func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Headers().Set("Content-Type", contentType)
+			io.WriteString(w, body)
+			return nil
+		}
+	}
+	stream := func(w *response.Writer, req *request.Request) *HandlerError {
+		w.Headers().Set("Content-Type", "application/json")
+		io.WriteString(w, large[:100])
+		w.Flush()
+		io.WriteString(w, large[100:])
+		return nil
+	}
+	file := func(w *response.Writer, req *request.Request) *HandlerError {
+		w.Headers().Set("Content-Type", "text/plain")
+		w.SetETag("v1")
+		return ServeContent(w, req, strings.NewReader(large))
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		handler        Handler
+		expectEncoding string
+		expectVary     string
+		expectChunked  bool
+		expectETag     string
+	}{
+		{name: "gzip", acceptEncoding: "gzip", handler: respond("application/json", large), expectEncoding: "gzip", expectVary: "Accept-Encoding"},
+		{name: "deflate", acceptEncoding: "deflate", handler: respond("application/json", large), expectEncoding: "deflate", expectVary: "Accept-Encoding"},
+		{name: "Preferred by weight", acceptEncoding: "gzip;q=0.5, deflate", handler: respond("application/json", large), expectEncoding: "deflate", expectVary: "Accept-Encoding"},
+		{name: "Server breaks ties", acceptEncoding: "deflate, gzip", handler: respond("application/json", large), expectEncoding: "gzip", expectVary: "Accept-Encoding"},
+		{name: "Wildcard", acceptEncoding: "*", handler: respond("application/json", large), expectEncoding: "gzip", expectVary: "Accept-Encoding"},
+		{name: "Wildcard with exclusion", acceptEncoding: "gzip;q=0, *", handler: respond("application/json", large), expectEncoding: "deflate", expectVary: "Accept-Encoding"},
+		{name: "Refused", acceptEncoding: "gzip;q=0, deflate;q=0", handler: respond("application/json", large), expectVary: "Accept-Encoding"},
+		{name: "Not accepted", handler: respond("application/json", large), expectVary: "Accept-Encoding"},
+		{name: "Unsupported coding", acceptEncoding: "br", handler: respond("application/json", large), expectVary: "Accept-Encoding"},
+		{name: "Small body", acceptEncoding: "gzip", handler: respond("application/json", `{"ok":true}`), expectVary: "Accept-Encoding"},
+		{name: "Incompressible type", acceptEncoding: "gzip", handler: respond("image/png", large)},
+		{name: "Structured suffix", acceptEncoding: "gzip", handler: respond("application/problem+json", large), expectEncoding: "gzip", expectVary: "Accept-Encoding"},
+		{name: "Streamed", acceptEncoding: "gzip", handler: stream, expectEncoding: "gzip", expectVary: "Accept-Encoding", expectChunked: true},
+		{name: "Content-Length streamed", acceptEncoding: "gzip", handler: file, expectEncoding: "gzip", expectVary: "Accept-Encoding", expectChunked: true, expectETag: `W/"v1"`},
+		{name: "HEAD", method: "HEAD", acceptEncoding: "gzip", handler: file, expectVary: "Accept-Encoding", expectETag: `"v1"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := &request.Request{
+				RequestLine: request.RequestLine{Method: method, RequestTarget: "/", HttpVersion: "HTTP/1.1"},
+				Headers:     headers.Headers{"host": "localhost"},
+			}
+			if tc.acceptEncoding != "" {
+				req.Headers.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+
+			buf := &bytes.Buffer{}
+			w := response.NewWriter(buf)
+			if handlerErr := Compress(tc.handler)(w, req); handlerErr != nil {
+				t.Fatalf("unexpected error: %v", handlerErr)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp, err := response.ResponseFromReader(buf, method)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := resp.Headers.Get("Content-Encoding"); got != tc.expectEncoding {
+				t.Errorf("got Content-Encoding %q, want %q", got, tc.expectEncoding)
+			}
+			if got := resp.Headers.Get("Vary"); got != tc.expectVary {
+				t.Errorf("got Vary %q, want %q", got, tc.expectVary)
+			}
+			if got := resp.Chunked(); got != tc.expectChunked {
+				t.Errorf("got chunked %v, want %v", got, tc.expectChunked)
+			}
+			if tc.expectETag != "" {
+				if got := resp.Headers.Get("ETag"); got != tc.expectETag {
+					t.Errorf("got ETag %q, want %q", got, tc.expectETag)
+				}
+			}
+			if method == "HEAD" {
+				if len(resp.Body) != 0 {
+					t.Errorf("got %d body bytes for HEAD", len(resp.Body))
+				}
+				return
+			}
+
+			var body io.Reader = bytes.NewReader(resp.Body)
+			switch tc.expectEncoding {
+			case "gzip":
+				body, err = gzip.NewReader(body)
+			case "deflate":
+				body, err = zlib.NewReader(body)
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.expectEncoding != "" && len(resp.Body) >= len(got) {
+				t.Errorf("compressed body is %d bytes, uncompressed %d", len(resp.Body), len(got))
+			}
+			want := large
+			if tc.name == "Small body" {
+				want = `{"ok":true}`
+			}
+			if string(got) != want {
+				t.Errorf("got body of %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestDecompressBody(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	io.WriteString(zw, strings.Repeat("a", 2048))
+	zw.Close()
+
+	echo := func(w *response.Writer, req *request.Request) *HandlerError {
+		w.Write(req.Body)
+		return nil
+	}
+
+	tests := []struct {
+		name         string
+		encoding     string
+		body         string
+		limit        int
+		expectStatus response.StatusCode
+	}{
+		{name: "Decoded", encoding: "gzip", body: gz.String(), limit: 4096},
+		{name: "Plain", body: "hello", limit: 4096},
+		{name: "Too large once decoded", encoding: "gzip", body: gz.String(), limit: 1024, expectStatus: response.StatusContentTooLarge},
+		{name: "Unsupported", encoding: "br", body: "hello", limit: 4096, expectStatus: response.StatusUnsupportedMediaType},
+		{name: "Corrupt", encoding: "gzip", body: "hello", limit: 4096, expectStatus: response.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &request.Request{
+				RequestLine:   request.RequestLine{Method: "POST", RequestTarget: "/", HttpVersion: "HTTP/1.1"},
+				Headers:       headers.Headers{"host": "localhost"},
+				Body:          []byte(tc.body),
+				ContentLength: len(tc.body),
+			}
+			if tc.encoding != "" {
+				req.Headers.Set("Content-Encoding", tc.encoding)
+			}
+
+			w := response.NewWriter(&bytes.Buffer{})
+			handlerErr := DecompressBody(tc.limit, echo)(w, req)
+			if tc.expectStatus != 0 {
+				if handlerErr == nil || handlerErr.StatusCode != tc.expectStatus {
+					t.Fatalf("got error %v, want status %d", handlerErr, tc.expectStatus)
+				}
+				if tc.expectStatus == response.StatusUnsupportedMediaType {
+					if got := w.Headers().Get("Accept-Encoding"); got != "gzip, deflate" {
+						t.Errorf("got Accept-Encoding %q, want %q", got, "gzip, deflate")
+					}
+				}
+				return
+			}
+			if handlerErr != nil {
+				t.Fatalf("unexpected error: %v", handlerErr)
+			}
+			if tc.encoding != "" && len(req.Body) != 2048 {
+				t.Errorf("got %d body bytes, want 2048", len(req.Body))
+			}
+		})
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	tests := []struct {
+		name     string
+		initial  string
+		expected string
+	}{
+		{name: "Empty", initial: "", expected: "Accept-Encoding"},
+		{name: "Appended", initial: "Origin", expected: "Origin, Accept-Encoding"},
+		{name: "Already listed", initial: "origin, accept-encoding", expected: "origin, accept-encoding"},
+		{name: "Star", initial: "*", expected: "*"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := headers.Headers{}
+			if tc.initial != "" {
+				h.Set("Vary", tc.initial)
+			}
+			addVary(h, "Accept-Encoding")
+			if got := h.Get("Vary"); got != tc.expected {
+				t.Errorf("got %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
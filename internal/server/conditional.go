@@ -0,0 +1,119 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+// httpDateFormats are the date formats a recipient must accept: the
+// preferred IMF-fixdate and the obsolete RFC 850 and asctime forms.
+var httpDateFormats = []string{
+	response.TimeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	time.ANSIC,
+}
+
+// CheckPreconditions evaluates req's conditional headers against the ETag
+// and Last-Modified set on w, in the order RFC 9110 section 13.2.2 gives:
+// If-Match, then If-Unmodified-Since, then If-None-Match, then
+// If-Modified-Since. When a condition fails it sets w's status to 304 Not
+// Modified or 412 Precondition Failed and reports true; the handler should
+// then return without writing a body.
+func CheckPreconditions(w *response.Writer, req *request.Request) bool {
+	etag := w.Headers().Get("ETag")
+	lastModified, hasLastModified := parseHTTPDate(w.Headers().Get("Last-Modified"))
+	method := req.RequestLine.Method
+
+	if ifMatch := req.Headers.Get("If-Match"); ifMatch != "" {
+		if !matchETag(ifMatch, etag, false) {
+			w.SetStatusCode(response.StatusPreconditionFailed)
+			return true
+		}
+	} else if since, ok := parseHTTPDate(req.Headers.Get("If-Unmodified-Since")); ok && hasLastModified {
+		if lastModified.After(since) {
+			w.SetStatusCode(response.StatusPreconditionFailed)
+			return true
+		}
+	}
+
+	if ifNoneMatch := req.Headers.Get("If-None-Match"); ifNoneMatch != "" {
+		if matchETag(ifNoneMatch, etag, true) {
+			if method == "GET" || method == "HEAD" {
+				notModified(w)
+			} else {
+				w.SetStatusCode(response.StatusPreconditionFailed)
+			}
+			return true
+		}
+	} else if method == "GET" || method == "HEAD" {
+		if since, ok := parseHTTPDate(req.Headers.Get("If-Modified-Since")); ok && hasLastModified {
+			if !lastModified.After(since) {
+				notModified(w)
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// notModified turns w into a 304, which carries the validators but none of
+// the fields that describe a body.
+func notModified(w *response.Writer) {
+	w.SetStatusCode(response.StatusNotModified)
+	w.Headers().Del("Content-Type")
+	w.Headers().Del("Content-Length")
+}
+
+// matchETag reports whether etag appears in list, a comma-separated list of
+// entity tags or "*". "*" matches any current representation, which is
+// assumed to exist when a handler checks preconditions. Weak comparison
+// ignores the W/ prefix; strong comparison never matches a weak tag.
+func matchETag(list, etag string, weak bool) bool {
+	list = strings.TrimSpace(list)
+	if list == "*" {
+		return true
+	}
+	if etag == "" {
+		return false
+	}
+
+	etag, etagWeak := strings.CutPrefix(etag, "W/")
+	if etagWeak && !weak {
+		return false
+	}
+
+	for list != "" {
+		list = strings.TrimLeft(list, " \t,")
+		candidate, candidateWeak := strings.CutPrefix(list, "W/")
+		if !strings.HasPrefix(candidate, `"`) {
+			return false
+		}
+		end := strings.IndexByte(candidate[1:], '"')
+		if end == -1 {
+			return false
+		}
+		tag := candidate[:end+2]
+		list = candidate[end+2:]
+
+		if tag == etag && (weak || !candidateWeak) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range httpDateFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	const (
+		etag    = `"v2"`
+		weakTag = `W/"v2"`
+		modTime = "Fri, 01 Mar 2024 12:00:00 GMT"
+		before  = "Thu, 29 Feb 2024 12:00:00 GMT"
+		after   = "Sat, 02 Mar 2024 12:00:00 GMT"
+	)
+
+	tests := []struct {
+		name           string
+		method         string
+		etag           string
+		requestHeaders map[string]string
+		expectStatus   response.StatusCode
+	}{
+		{name: "No conditions", expectStatus: response.StatusOk},
+		{name: "If-None-Match hit", requestHeaders: map[string]string{"If-None-Match": etag}, expectStatus: response.StatusNotModified},
+		{name: "If-None-Match in list", requestHeaders: map[string]string{"If-None-Match": `"v1", "v2"`}, expectStatus: response.StatusNotModified},
+		{name: "If-None-Match tag with comma", etag: `"a,b"`, requestHeaders: map[string]string{"If-None-Match": `"x", "a,b"`}, expectStatus: response.StatusNotModified},
+		{name: "If-None-Match weak compare", requestHeaders: map[string]string{"If-None-Match": weakTag}, expectStatus: response.StatusNotModified},
+		{name: "If-None-Match miss", requestHeaders: map[string]string{"If-None-Match": `"v1"`}, expectStatus: response.StatusOk},
+		{name: "If-None-Match star", requestHeaders: map[string]string{"If-None-Match": "*"}, expectStatus: response.StatusNotModified},
+		{name: "If-None-Match on POST", method: "POST", requestHeaders: map[string]string{"If-None-Match": etag}, expectStatus: response.StatusPreconditionFailed},
+		{name: "If-Match hit", method: "PUT", requestHeaders: map[string]string{"If-Match": etag}, expectStatus: response.StatusOk},
+		{name: "If-Match miss", method: "PUT", requestHeaders: map[string]string{"If-Match": `"v1"`}, expectStatus: response.StatusPreconditionFailed},
+		{name: "If-Match is strong", method: "PUT", requestHeaders: map[string]string{"If-Match": weakTag}, expectStatus: response.StatusPreconditionFailed},
+		{name: "If-Match against weak ETag", method: "PUT", etag: weakTag, requestHeaders: map[string]string{"If-Match": etag}, expectStatus: response.StatusPreconditionFailed},
+		{name: "If-Match star", method: "PUT", requestHeaders: map[string]string{"If-Match": "*"}, expectStatus: response.StatusOk},
+		{name: "If-Modified-Since unchanged", requestHeaders: map[string]string{"If-Modified-Since": modTime}, expectStatus: response.StatusNotModified},
+		{name: "If-Modified-Since later", requestHeaders: map[string]string{"If-Modified-Since": after}, expectStatus: response.StatusNotModified},
+		{name: "If-Modified-Since changed", requestHeaders: map[string]string{"If-Modified-Since": before}, expectStatus: response.StatusOk},
+		{name: "If-Modified-Since obsolete format", requestHeaders: map[string]string{"If-Modified-Since": "Friday, 01-Mar-24 12:00:00 GMT"}, expectStatus: response.StatusNotModified},
+		{name: "If-Modified-Since invalid date", requestHeaders: map[string]string{"If-Modified-Since": "yesterday"}, expectStatus: response.StatusOk},
+		{name: "If-Modified-Since ignored for POST", method: "POST", requestHeaders: map[string]string{"If-Modified-Since": modTime}, expectStatus: response.StatusOk},
+		{
+			name:           "If-None-Match takes precedence over If-Modified-Since",
+			requestHeaders: map[string]string{"If-None-Match": `"v1"`, "If-Modified-Since": after},
+			expectStatus:   response.StatusOk,
+		},
+		{name: "If-Unmodified-Since unchanged", method: "PUT", requestHeaders: map[string]string{"If-Unmodified-Since": modTime}, expectStatus: response.StatusOk},
+		{name: "If-Unmodified-Since changed", method: "PUT", requestHeaders: map[string]string{"If-Unmodified-Since": before}, expectStatus: response.StatusPreconditionFailed},
+		{
+			name:           "If-Match takes precedence over If-Unmodified-Since",
+			method:         "PUT",
+			requestHeaders: map[string]string{"If-Match": etag, "If-Unmodified-Since": before},
+			expectStatus:   response.StatusOk,
+		},
+		{
+			name:           "If-Match checked before If-None-Match",
+			requestHeaders: map[string]string{"If-Match": `"v1"`, "If-None-Match": etag},
+			expectStatus:   response.StatusPreconditionFailed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := &request.Request{
+				RequestLine: request.RequestLine{Method: method, RequestTarget: "/", HttpVersion: "HTTP/1.1"},
+				Headers:     headers.Headers{"host": "localhost"},
+			}
+			for key, value := range tc.requestHeaders {
+				req.Headers.Set(key, value)
+			}
+
+			w := response.NewWriter(nil)
+			w.Headers().Set("ETag", etag)
+			if tc.etag != "" {
+				w.Headers().Set("ETag", tc.etag)
+			}
+			w.Headers().Set("Last-Modified", modTime)
+
+			handled := CheckPreconditions(w, req)
+			if handled != (tc.expectStatus != response.StatusOk) {
+				t.Errorf("got handled %v for status %d", handled, w.StatusCode())
+			}
+			if w.StatusCode() != tc.expectStatus {
+				t.Errorf("got status %d, want %d", w.StatusCode(), tc.expectStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+// ServeContent answers req with content. Conditional headers are checked
+// first with CheckPreconditions, and a Range header is honored on GET
+// requests. One range is sent as a 206 with a Content-Range, several as a
+// multipart/byteranges body, and ranges that miss the content entirely get
+// a 416. HEAD requests get the head of the full response only.
+//
+// Set Content-Type, ETag and Last-Modified on w before calling it; the
+// validators are what conditional requests and If-Range are checked
+// against. The body streams from content with a Content-Length rather than
+// being buffered.
+func ServeContent(w *response.Writer, req *request.Request, content io.ReadSeeker) *HandlerError {
+	if CheckPreconditions(w, req) {
+		return nil
+	}
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+
+	w.Headers().Set("Accept-Ranges", "bytes")
+
+	var ranges []headers.ByteRange
+	if value := req.Headers.Get("Range"); value != "" && req.RequestLine.Method == "GET" && ifRangeMatches(w, req) {
+		ranges, err = headers.ParseRange(value, size)
+		if errors.Is(err, headers.ErrUnsatisfiableRange) {
+			w.Headers().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.SetStatusCode(response.StatusRangeNotSatisfiable)
+			return nil
+		}
+		// Ranges that overlap enough to ask for more than the whole content
+		// are answered with the whole content instead.
+		var total int64
+		for _, r := range ranges {
+			total += r.Length
+		}
+		if total > size {
+			ranges = nil
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		return sendRanges(w, req, content, size, headers.ByteRange{Start: 0, Length: size})
+	case 1:
+		w.SetStatusCode(response.StatusPartialContent)
+		w.Headers().Set("Content-Range", ranges[0].ContentRange(size))
+		return sendRanges(w, req, content, ranges[0].Length, ranges[0])
+	}
+
+	boundary := rand.Text()
+	contentType := w.Headers().Get("Content-Type")
+	parts := make([]string, len(ranges))
+	closing := "\r\n--" + boundary + "--\r\n"
+	length := int64(len(closing))
+	for i, r := range ranges {
+		parts[i] = fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", boundary, contentType, r.ContentRange(size))
+		length += int64(len(parts[i])) + r.Length
+	}
+
+	w.SetStatusCode(response.StatusPartialContent)
+	w.Headers().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	w.Headers().Set("Content-Length", strconv.FormatInt(length, 10))
+	if err := w.Flush(); err != nil {
+		return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+	for i, r := range ranges {
+		if _, err := io.WriteString(w, parts[i]); err != nil {
+			return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+		}
+		if err := copyRange(w, content, r); err != nil {
+			return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+		}
+	}
+	if _, err := io.WriteString(w, closing); err != nil {
+		return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+	return nil
+}
+
+// sendRanges sets the Content-Length to length and, unless req is a HEAD,
+// sends the head followed by each range of content.
+func sendRanges(w *response.Writer, req *request.Request, content io.ReadSeeker, length int64, ranges ...headers.ByteRange) *HandlerError {
+	w.Headers().Set("Content-Length", strconv.FormatInt(length, 10))
+	if req.RequestLine.Method == "HEAD" {
+		return nil
+	}
+
+	if err := w.Flush(); err != nil {
+		return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+	for _, r := range ranges {
+		if err := copyRange(w, content, r); err != nil {
+			return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+func copyRange(w io.Writer, content io.ReadSeeker, r headers.ByteRange) error {
+	if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(w, content, r.Length)
+	return err
+}
+
+// ifRangeMatches reports whether a Range header should be honored given
+// req's If-Range. An entity tag must match the ETag set on w by strong
+// comparison; a date must equal its Last-Modified exactly.
+func ifRangeMatches(w *response.Writer, req *request.Request) bool {
+	ifRange := req.Headers.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == w.Headers().Get("ETag")
+	}
+	if strings.HasPrefix(ifRange, "W/") {
+		return false
+	}
+	lastModified := w.Headers().Get("Last-Modified")
+	return lastModified != "" && ifRange == lastModified
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+func TestServeContent(t *testing.T) {
+	const content = "0123456789abcdefghijklmnopqrstuvwxyz"
+	const etag = `"v1"`
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+	tests := []struct {
+		name                string
+		method              string
+		requestHeaders      map[string]string
+		expectStatus        response.StatusCode
+		expectBody          string
+		expectContentRange  string
+		expectContentLength string
+		expectParts         []string
+	}{
+		{name: "No range", expectStatus: response.StatusOk, expectBody: content, expectContentLength: "36"},
+		{
+			name:                "Single range",
+			requestHeaders:      map[string]string{"Range": "bytes=10-15"},
+			expectStatus:        response.StatusPartialContent,
+			expectBody:          "abcdef",
+			expectContentRange:  "bytes 10-15/36",
+			expectContentLength: "6",
+		},
+		{
+			name:               "Suffix range",
+			requestHeaders:     map[string]string{"Range": "bytes=-3"},
+			expectStatus:       response.StatusPartialContent,
+			expectBody:         "xyz",
+			expectContentRange: "bytes 33-35/36",
+		},
+		{
+			name:           "Multiple ranges",
+			requestHeaders: map[string]string{"Range": "bytes=0-1,-2,5-"},
+			expectStatus:   response.StatusPartialContent,
+			expectParts:    []string{"01", "yz", content[5:]},
+		},
+		{
+			name:               "Unsatisfiable",
+			requestHeaders:     map[string]string{"Range": "bytes=100-"},
+			expectStatus:       response.StatusRangeNotSatisfiable,
+			expectContentRange: "bytes */36",
+		},
+		{
+			name:           "Invalid range ignored",
+			requestHeaders: map[string]string{"Range": "bytes=9-1"},
+			expectStatus:   response.StatusOk,
+			expectBody:     content,
+		},
+		{
+			name:           "Overlapping ranges larger than content",
+			requestHeaders: map[string]string{"Range": "bytes=0-,0-,0-"},
+			expectStatus:   response.StatusOk,
+			expectBody:     content,
+		},
+		{
+			name:                "HEAD ignores range",
+			method:              "HEAD",
+			requestHeaders:      map[string]string{"Range": "bytes=0-1"},
+			expectStatus:        response.StatusOk,
+			expectContentLength: "36",
+		},
+		{
+			name:               "If-Range matching ETag",
+			requestHeaders:     map[string]string{"Range": "bytes=0-1", "If-Range": etag},
+			expectStatus:       response.StatusPartialContent,
+			expectBody:         "01",
+			expectContentRange: "bytes 0-1/36",
+		},
+		{
+			name:           "If-Range stale ETag",
+			requestHeaders: map[string]string{"Range": "bytes=0-1", "If-Range": `"v0"`},
+			expectStatus:   response.StatusOk,
+			expectBody:     content,
+		},
+		{
+			name:           "If-Range weak ETag",
+			requestHeaders: map[string]string{"Range": "bytes=0-1", "If-Range": `W/"v1"`},
+			expectStatus:   response.StatusOk,
+			expectBody:     content,
+		},
+		{
+			name:               "If-Range matching date",
+			requestHeaders:     map[string]string{"Range": "bytes=0-1", "If-Range": lastModified},
+			expectStatus:       response.StatusPartialContent,
+			expectBody:         "01",
+			expectContentRange: "bytes 0-1/36",
+		},
+		{
+			name:           "If-Range other date",
+			requestHeaders: map[string]string{"Range": "bytes=0-1", "If-Range": "Tue, 03 Jan 2006 15:04:05 GMT"},
+			expectStatus:   response.StatusOk,
+			expectBody:     content,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := &request.Request{
+				RequestLine: request.RequestLine{Method: method, RequestTarget: "/", HttpVersion: "HTTP/1.1"},
+				Headers:     headers.Headers{"host": "localhost"},
+			}
+			for key, value := range tc.requestHeaders {
+				req.Headers.Set(key, value)
+			}
+
+			buf := &bytes.Buffer{}
+			w := response.NewWriter(buf)
+			w.Headers().Set("ETag", etag)
+			w.Headers().Set("Last-Modified", lastModified)
+			if handlerErr := ServeContent(w, req, strings.NewReader(content)); handlerErr != nil {
+				t.Fatalf("unexpected error: %v", handlerErr)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp, err := response.ResponseFromReader(buf, method)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if resp.StatusLine.StatusCode != tc.expectStatus {
+				t.Errorf("got status %d, want %d", resp.StatusLine.StatusCode, tc.expectStatus)
+			}
+			if got := resp.Headers.Get("Content-Range"); got != tc.expectContentRange {
+				t.Errorf("got Content-Range %q, want %q", got, tc.expectContentRange)
+			}
+			if tc.expectContentLength != "" {
+				if got := resp.Headers.Get("Content-Length"); got != tc.expectContentLength {
+					t.Errorf("got Content-Length %q, want %q", got, tc.expectContentLength)
+				}
+			}
+
+			if tc.expectParts == nil {
+				if string(resp.Body) != tc.expectBody {
+					t.Errorf("got body %q, want %q", resp.Body, tc.expectBody)
+				}
+				return
+			}
+
+			mediaType, params, err := mime.ParseMediaType(resp.Headers.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/byteranges" {
+				t.Fatalf("got Content-Type %q, want multipart/byteranges", resp.Headers.Get("Content-Type"))
+			}
+			mr := multipart.NewReader(bytes.NewReader(resp.Body), params["boundary"])
+			for i, want := range tc.expectParts {
+				part, err := mr.NextPart()
+				if err != nil {
+					t.Fatalf("part %d: unexpected error: %v", i, err)
+				}
+				if got := part.Header.Get("Content-Type"); got != "text/plain" {
+					t.Errorf("part %d: got Content-Type %q, want %q", i, got, "text/plain")
+				}
+				got, _ := io.ReadAll(part)
+				if string(got) != want {
+					t.Errorf("part %d: got %q, want %q", i, got, want)
+				}
+			}
+			if _, err := mr.NextPart(); err != io.EOF {
+				t.Errorf("got %v after last part, want EOF", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+// indexFile is served in place of a directory that contains it.
+const indexFile = "index.html"
+
+// contentTypes maps file extensions to the Content-Type they are served
+// with. Anything else is served as application/octet-stream.
+var contentTypes = map[string]string{
+	".css":   "text/css; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".gif":   "image/gif",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/x-icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".map":   "application/json",
+	".md":    "text/markdown; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".txt":   "text/plain; charset=utf-8",
+	".wasm":  "application/wasm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "application/xml",
+	".zip":   "application/zip",
+}
+
+// FileServer serves GET and HEAD requests from the files under a directory.
+// A directory is answered with its index.html, or with a listing of its
+// entries when Listing is set. Paths are resolved with os.Root, so neither
+// ".." segments nor symlinks can reach anything outside the directory.
+type FileServer struct {
+	// Listing enables HTML listings for directories without an index.html.
+	Listing bool
+
+	root *os.Root
+}
+
+// NewFileServer returns a FileServer for the directory dir.
+func NewFileServer(dir string) (*FileServer, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &FileServer{root: root}, nil
+}
+
+// Close releases the directory the FileServer serves from.
+func (fsrv *FileServer) Close() error {
+	return fsrv.root.Close()
+}
+
+// Serve answers req with the file its target names. Pass it to Serve as the
+// server's Handler.
+func (fsrv *FileServer) Serve(w *response.Writer, req *request.Request) *HandlerError {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		w.Headers().Set("Allow", "GET, HEAD")
+		return &HandlerError{
+			StatusCode: response.StatusMethodNotAllowed,
+			Message:    fmt.Sprintf("method %s not allowed", method),
+		}
+	}
+
+	urlPath, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	name, err := cleanPath(urlPath)
+	if err != nil {
+		return &HandlerError{StatusCode: response.StatusBadRequest, Message: err.Error()}
+	}
+
+	info, err := fsrv.root.Stat(name)
+	if err != nil {
+		return fileError(err)
+	}
+
+	if info.IsDir() {
+		// Relative links in the page and the listing resolve against the
+		// directory only when its URL ends in a slash.
+		if !strings.HasSuffix(urlPath, "/") {
+			// The location is built from the cleaned name: a raw path such
+			// as "//docs" would send browsers to the host "docs".
+			location := "/"
+			if name != "." {
+				location = (&url.URL{Path: "/" + name + "/"}).EscapedPath()
+			}
+			if query != "" {
+				location += "?" + query
+			}
+			if err := w.Headers().Set("Location", location); err != nil {
+				return &HandlerError{StatusCode: response.StatusBadRequest, Message: err.Error()}
+			}
+			w.SetStatusCode(response.StatusMovedPermanently)
+			return nil
+		}
+
+		index := path.Join(name, indexFile)
+		if info, err := fsrv.root.Stat(index); err == nil && info.Mode().IsRegular() {
+			return fsrv.serveFile(w, req, index)
+		}
+
+		if !fsrv.Listing {
+			return &HandlerError{StatusCode: response.StatusNotFound, Message: "not found"}
+		}
+		return fsrv.serveListing(w, req, name)
+	}
+
+	return fsrv.serveFile(w, req, name)
+}
+
+func (fsrv *FileServer) serveFile(w *response.Writer, req *request.Request, name string) *HandlerError {
+	f, err := fsrv.root.Open(name)
+	if err != nil {
+		return fileError(err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fileError(err)
+	}
+	if !info.Mode().IsRegular() {
+		return &HandlerError{StatusCode: response.StatusNotFound, Message: "not found"}
+	}
+
+	// The modification time and size change whenever a file is rewritten,
+	// which is as strong a guarantee as hashing it without reading it.
+	if err := w.SetETag(fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())); err != nil {
+		return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+	w.SetLastModified(info.ModTime())
+	w.Headers().Set("Content-Type", contentType(name))
+	return ServeContent(w, req, f)
+}
+
+func (fsrv *FileServer) serveListing(w *response.Writer, req *request.Request, name string) *HandlerError {
+	dir, err := fsrv.root.Open(name)
+	if err != nil {
+		return fileError(err)
+	}
+	defer dir.Close()
+
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return fileError(err)
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	title := html.EscapeString("Index of /" + strings.TrimPrefix(name, "."))
+	var page bytes.Buffer
+	fmt.Fprintf(&page, "<!doctype html>\n<title>%s</title>\n<h1>%s</h1>\n<ul>\n", title, title)
+	if name != "." {
+		page.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		label := entry.Name()
+		if entry.IsDir() {
+			label += "/"
+		}
+		href := (&url.URL{Path: label}).EscapedPath()
+		// A name with a colon would otherwise read as a URL scheme.
+		if strings.Contains(label, ":") {
+			href = "./" + href
+		}
+		fmt.Fprintf(&page, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(label))
+	}
+	page.WriteString("</ul>\n")
+
+	w.Headers().Set("Content-Type", "text/html; charset=utf-8")
+	return ServeContent(w, req, bytes.NewReader(page.Bytes()))
+}
+
+// cleanPath turns the path of a request target into a name relative to the
+// served directory. The path is unescaped before it is cleaned, so an
+// encoded "%2e%2e" is treated like "..", and cleaning a rooted path drops
+// any ".." that would climb above it.
+func cleanPath(urlPath string) (string, error) {
+	if !strings.HasPrefix(urlPath, "/") {
+		return "", fmt.Errorf("invalid request target %q", urlPath)
+	}
+
+	p, err := url.PathUnescape(urlPath)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(p, "\x00\\") {
+		return "", fmt.Errorf("invalid request path %q", p)
+	}
+
+	name := strings.TrimPrefix(path.Clean(p), "/")
+	if name == "" {
+		return ".", nil
+	}
+	return name, nil
+}
+
+func contentType(name string) string {
+	if ct, ok := contentTypes[strings.ToLower(path.Ext(name))]; ok {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// fileError maps an error from opening a path to a response. Paths that do
+// not exist, cannot be read, or that os.Root refuses to follow out of the
+// directory are all answered the same way so none can be told apart.
+func fileError(err error) *HandlerError {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return &HandlerError{StatusCode: response.StatusNotFound, Message: "not found"}
+	}
+	return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+}
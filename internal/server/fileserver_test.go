@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"secret.txt":             "top secret",
+		"site/index.html":        "<h1>home</h1>",
+		"site/app.js":            "console.log(1)",
+		"site/logo.PNG":          "png",
+		"site/data.bin":          "\x00\x01",
+		"site/docs/guide.md":     "# Guide",
+		"site/docs/a <b>.txt":    "escaped",
+		"site/empty/placeholder": "",
+	})
+	if err := os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(base, "site", "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	fsrv, err := NewFileServer(filepath.Join(base, "site"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer fsrv.Close()
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		requestHeaders map[string]string
+		listing        bool
+		expectStatus   response.StatusCode
+		expectBody     string
+		expectContains string
+		expectHeaders  map[string]string
+	}{
+		{
+			name:          "File",
+			target:        "/app.js",
+			expectStatus:  response.StatusOk,
+			expectBody:    "console.log(1)",
+			expectHeaders: map[string]string{"Content-Type": "text/javascript; charset=utf-8", "Content-Length": "14"},
+		},
+		{
+			name:          "Extension case ignored",
+			target:        "/logo.PNG",
+			expectStatus:  response.StatusOk,
+			expectHeaders: map[string]string{"Content-Type": "image/png"},
+			expectBody:    "png",
+		},
+		{
+			name:          "Unknown extension",
+			target:        "/data.bin",
+			expectStatus:  response.StatusOk,
+			expectHeaders: map[string]string{"Content-Type": "application/octet-stream"},
+			expectBody:    "\x00\x01",
+		},
+		{
+			name:          "Index",
+			target:        "/",
+			expectStatus:  response.StatusOk,
+			expectHeaders: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+			expectBody:    "<h1>home</h1>",
+		},
+		{
+			name:          "HEAD sends length without body",
+			method:        "HEAD",
+			target:        "/app.js",
+			expectStatus:  response.StatusOk,
+			expectHeaders: map[string]string{"Content-Length": "14"},
+			expectBody:    "",
+		},
+		{
+			name:          "Directory without slash redirects",
+			target:        "/docs?x=1",
+			expectStatus:  response.StatusMovedPermanently,
+			expectHeaders: map[string]string{"Location": "/docs/?x=1"},
+		},
+		{
+			name:          "Redirect stays on this host",
+			target:        "//docs",
+			expectStatus:  response.StatusMovedPermanently,
+			expectHeaders: map[string]string{"Location": "/docs/"},
+		},
+		{name: "Directory without index", target: "/docs/", expectStatus: response.StatusNotFound},
+		{
+			name:           "Listing",
+			target:         "/docs/",
+			listing:        true,
+			expectStatus:   response.StatusOk,
+			expectContains: `<a href="a%20%3Cb%3E.txt">a &lt;b&gt;.txt</a>`,
+		},
+		{name: "Missing file", target: "/nope.txt", expectStatus: response.StatusNotFound},
+		{name: "Query ignored", target: "/app.js?v=2", expectStatus: response.StatusOk, expectBody: "console.log(1)"},
+		{name: "Encoded path", target: "/docs/a%20%3Cb%3E.txt", expectStatus: response.StatusOk, expectBody: "escaped"},
+		{name: "Traversal", target: "/../secret.txt", expectStatus: response.StatusNotFound},
+		{name: "Encoded traversal", target: "/%2e%2e/secret.txt", expectStatus: response.StatusNotFound},
+		{name: "Symlink out of root", target: "/link.txt", expectStatus: response.StatusNotFound},
+		{name: "Backslash", target: "/..%5csecret.txt", expectStatus: response.StatusBadRequest},
+		{name: "Bad escape", target: "/%zz", expectStatus: response.StatusBadRequest},
+		{
+			name:           "Not modified",
+			target:         "/app.js",
+			requestHeaders: map[string]string{"If-Modified-Since": "Fri, 01 Jan 2100 00:00:00 GMT"},
+			expectStatus:   response.StatusNotModified,
+		},
+		{
+			name:           "Range",
+			target:         "/app.js",
+			requestHeaders: map[string]string{"Range": "bytes=8-10"},
+			expectStatus:   response.StatusPartialContent,
+			expectBody:     "log",
+		},
+		{name: "Method not allowed", method: "POST", target: "/app.js", expectStatus: response.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			fsrv.Listing = tc.listing
+
+			req := &request.Request{
+				RequestLine: request.RequestLine{Method: method, RequestTarget: tc.target, HttpVersion: "HTTP/1.1"},
+				Headers:     headers.Headers{"host": "localhost"},
+			}
+			for key, value := range tc.requestHeaders {
+				req.Headers.Set(key, value)
+			}
+			buf := &bytes.Buffer{}
+			w := response.NewWriter(buf)
+			handlerErr := fsrv.Serve(w, req)
+
+			if tc.expectStatus >= 400 {
+				if handlerErr == nil || handlerErr.StatusCode != tc.expectStatus {
+					t.Fatalf("got error %v, want status %d", handlerErr, tc.expectStatus)
+				}
+				return
+			}
+
+			if handlerErr != nil {
+				t.Fatalf("unexpected error: %v", handlerErr)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp, err := response.ResponseFromReader(buf, method)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if resp.StatusLine.StatusCode != tc.expectStatus {
+				t.Errorf("got status %d, want %d", resp.StatusLine.StatusCode, tc.expectStatus)
+			}
+			for key, want := range tc.expectHeaders {
+				if got := resp.Headers.Get(key); got != want {
+					t.Errorf("got %s %q, want %q", key, got, want)
+				}
+			}
+			if tc.expectContains != "" {
+				if !strings.Contains(string(resp.Body), tc.expectContains) {
+					t.Errorf("body %q does not contain %q", resp.Body, tc.expectContains)
+				}
+			} else if string(resp.Body) != tc.expectBody {
+				t.Errorf("got body %q, want %q", resp.Body, tc.expectBody)
+			}
+		})
+	}
+}
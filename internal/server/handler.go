@@ -1,19 +1,101 @@
 package server
 
 import (
-	"fmt"
 	"io"
 
 	"github.com/portbound/tcp-to-http/internal/request"
 	"github.com/portbound/tcp-to-http/internal/response"
 )
 
-type Handler func(w io.Writer, req *request.Request) *HandlerError
+// Handler builds a response to req with w. Returning a HandlerError before
+// anything has been flushed sends that error instead.
+//
+// req is the handler's to keep: the server never reuses it, its header map
+// or its Body for another request, so they may be used after the handler
+// returns. A deferred body, though, must be read before returning, since
+// the connection is closed then, and uploads ParseMultipartForm spilled to
+// temporary files are removed.
+//
+// Handler used to take an io.Writer. *response.Writer is one and buffers
+// the body the same way, so a handler written for the old signature only
+// needs its first parameter's type changed, or can be wrapped as is with
+// WriterHandler.
+type Handler func(w *response.Writer, req *request.Request) *HandlerError
+
+// WriterHandler adapts f, a handler written for the io.Writer signature
+// Handler had before it took a *response.Writer, so existing handlers keep
+// working unchanged.
+func WriterHandler(f func(w io.Writer, req *request.Request) *HandlerError) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		return f(w, req)
+	}
+}
+
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
 }
 
-func (e *HandlerError) Write(w io.Writer) {
-	fmt.Fprintf(w, "error in handler: %v", e)
+func (e *HandlerError) Error() string {
+	return e.Message
+}
+
+// Write sends e to w as a complete plain text response, for errors found
+// before there is a request to render them for.
+func (e *HandlerError) Write(w io.Writer) error {
+	rw := response.NewWriter(w)
+	DefaultErrorRenderer(rw, nil, e)
+	return rw.Close()
+}
+
+// An ErrorRenderer writes the response for a HandlerError to w. Anything
+// the handler buffered has been discarded by then, along with the headers
+// describing it, while other headers it set, such as Allow on a 405, are
+// kept. req is nil for a request that could not be parsed.
+type ErrorRenderer func(w *response.Writer, req *request.Request, e *HandlerError)
+
+// DefaultErrorRenderer is what the server renders errors with unless
+// Server.ErrorRenderer is set: RFC 9457 problem details for clients that
+// prefer JSON, and the Message as plain text for everyone else.
+func DefaultErrorRenderer(w *response.Writer, req *request.Request, e *HandlerError) {
+	if req != nil {
+		addVary(w.Headers(), "Accept")
+		if prefersProblem(req) && e.WriteProblem(w) == nil {
+			return
+		}
+	}
+
+	message := e.Message
+	if message == "" {
+		message = response.StatusText(e.StatusCode)
+	}
+	w.SetStatusCode(e.StatusCode)
+	w.Headers().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, message+"\n")
+}
+
+// RenderErrors returns a Handler that renders errors returned by next with
+// render, for branded error pages or a JSON shape of one's own on some
+// routes; Server.ErrorRenderer does the same for all of them.
+// An error returned after the response has started cannot be rendered and
+// is passed on for the server to abandon the response.
+func RenderErrors(render ErrorRenderer, next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		handlerErr := next(w, req)
+		if handlerErr == nil || w.HeadWritten() || w.Hijacked() {
+			return handlerErr
+		}
+		renderError(w, req, handlerErr, render)
+		return nil
+	}
+}
+
+// renderError replaces whatever the handler buffered with render's
+// response to e. A HEAD request gets the head alone.
+func renderError(w *response.Writer, req *request.Request, e *HandlerError, render ErrorRenderer) {
+	w.Discard()
+	if req != nil && req.RequestLine.Method == "HEAD" {
+		w.OmitBody()
+	}
+	render(w, req, e)
 }
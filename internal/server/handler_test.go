@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+func TestRenderErrors(t *testing.T) {
+	// partial buffers a body and its validators before failing.
+	partial := func(w *response.Writer, req *request.Request) *HandlerError {
+		w.Headers().Set("Content-Type", "text/html")
+		w.SetETag("v1")
+		io.WriteString(w, "<p>half a page")
+		return &HandlerError{StatusCode: response.StatusInternalServerError, Message: "template failed"}
+	}
+	notAllowed := func(w *response.Writer, req *request.Request) *HandlerError {
+		w.Headers().Set("Allow", "GET, HEAD")
+		return &HandlerError{StatusCode: response.StatusMethodNotAllowed}
+	}
+	streamed := func(w *response.Writer, req *request.Request) *HandlerError {
+		io.WriteString(w, "started")
+		w.Flush()
+		return &HandlerError{StatusCode: response.StatusInternalServerError, Message: "too late"}
+	}
+	branded := func(w *response.Writer, req *request.Request, e *HandlerError) {
+		w.SetStatusCode(e.StatusCode)
+		w.Headers().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<h1>%d</h1>", e.StatusCode)
+	}
+
+	tests := []struct {
+		name          string
+		handler       Handler
+		render        ErrorRenderer
+		method        string
+		accept        string
+		expectStatus  response.StatusCode
+		expectType    string
+		expectBody    string
+		expectHeaders map[string]string
+		expectErr     bool
+	}{
+		{
+			name:          "Plain text",
+			handler:       partial,
+			render:        DefaultErrorRenderer,
+			expectStatus:  response.StatusInternalServerError,
+			expectType:    "text/plain; charset=utf-8",
+			expectBody:    "template failed\n",
+			expectHeaders: map[string]string{"ETag": "", "Content-Length": "16"},
+		},
+		{
+			name:         "Problem details",
+			handler:      partial,
+			render:       DefaultErrorRenderer,
+			accept:       "application/json",
+			expectStatus: response.StatusInternalServerError,
+			expectType:   "application/problem+json",
+			expectBody:   `{"title":"Internal Server Error","status":500,"detail":"template failed"}` + "\n",
+		},
+		{
+			name:          "Headers kept and reason as message",
+			handler:       notAllowed,
+			render:        DefaultErrorRenderer,
+			expectStatus:  response.StatusMethodNotAllowed,
+			expectType:    "text/plain; charset=utf-8",
+			expectBody:    "Method Not Allowed\n",
+			expectHeaders: map[string]string{"Allow": "GET, HEAD"},
+		},
+		{
+			name:         "Custom renderer",
+			handler:      partial,
+			render:       branded,
+			expectStatus: response.StatusInternalServerError,
+			expectType:   "text/html",
+			expectBody:   "<h1>500</h1>",
+		},
+		{
+			name:          "HEAD gets the head alone",
+			handler:       partial,
+			render:        DefaultErrorRenderer,
+			method:        "HEAD",
+			expectStatus:  response.StatusInternalServerError,
+			expectType:    "text/plain; charset=utf-8",
+			expectBody:    "",
+			expectHeaders: map[string]string{"Content-Length": "16"},
+		},
+		{name: "After the head", handler: streamed, render: branded, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := &request.Request{
+				RequestLine: request.RequestLine{Method: method, RequestTarget: "/", HttpVersion: "HTTP/1.1"},
+				Headers:     headers.Headers{"host": "localhost"},
+			}
+			if tc.accept != "" {
+				req.Headers.Set("Accept", tc.accept)
+			}
+
+			buf := &bytes.Buffer{}
+			w := response.NewWriter(buf)
+			handlerErr := RenderErrors(tc.render, tc.handler)(w, req)
+			if tc.expectErr {
+				if handlerErr == nil {
+					t.Fatal("expected the error to be passed on")
+				}
+				return
+			}
+			if handlerErr != nil {
+				t.Fatalf("unexpected error: %v", handlerErr)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if method == "HEAD" && strings.Contains(buf.String(), "template failed") {
+				t.Errorf("got a body in %q", buf.String())
+			}
+			resp, err := response.ResponseFromReader(buf, method)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusLine.StatusCode != tc.expectStatus {
+				t.Errorf("got status %d, want %d", resp.StatusLine.StatusCode, tc.expectStatus)
+			}
+			if got := resp.Headers.Get("Content-Type"); got != tc.expectType {
+				t.Errorf("got Content-Type %q, want %q", got, tc.expectType)
+			}
+			if string(resp.Body) != tc.expectBody {
+				t.Errorf("got body %q, want %q", resp.Body, tc.expectBody)
+			}
+			for name, want := range tc.expectHeaders {
+				if got := resp.Headers.Get(name); got != want {
+					t.Errorf("got %s %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerErrorWrite(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e := &HandlerError{StatusCode: response.StatusBadRequest, Message: "invalid request line"}
+	if err := e.Write(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "HTTP/1.1 400 Bad Request\r\n" +
+		"Connection: close\r\n" +
+		"Content-Length: 21\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"invalid request line\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+	if !strings.Contains(e.Error(), "invalid request line") {
+		t.Errorf("got Error() %q", e.Error())
+	}
+}
+
+func TestWriterHandler(t *testing.T) {
+	// legacy is written for the io.Writer signature Handler used to have.
+	legacy := func(w io.Writer, req *request.Request) *HandlerError {
+		fmt.Fprintf(w, "All good, frfr\n")
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	if handlerErr := WriterHandler(legacy)(w, &request.Request{}); handlerErr != nil {
+		t.Fatalf("unexpected error: %v", handlerErr)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "HTTP/1.1 200 OK\r\n" +
+		"Connection: close\r\n" +
+		"Content-Length: 15\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"All good, frfr\n"
+	if buf.String() != want {
+		t.Errorf("got\n%q\nwant\n%q", buf.String(), want)
+	}
+}
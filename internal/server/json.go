@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+// DecodeJSON decodes the JSON body of req into v with request.DecodeJSON,
+// answering a body that is not JSON with 415, one larger than limit with
+// 413 and one that does not decode into v with 400.
+func DecodeJSON(req *request.Request, v any, limit int) *HandlerError {
+	err := req.DecodeJSON(v, limit)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, request.ErrNotJSON):
+		return &HandlerError{StatusCode: response.StatusUnsupportedMediaType, Message: err.Error()}
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return &HandlerError{StatusCode: response.StatusContentTooLarge, Message: err.Error()}
+	}
+	return &HandlerError{StatusCode: response.StatusBadRequest, Message: err.Error()}
+}
+
+// Problem is an RFC 9457 problem details object, the body of an
+// application/problem+json response.
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// Problem returns e as problem details. Type is left out, which means
+// "about:blank": the status code says all there is to say about the kind
+// of problem, and Message describes this occurrence of it.
+func (e *HandlerError) Problem() Problem {
+	return Problem{
+		Title:  response.StatusText(e.StatusCode),
+		Status: int(e.StatusCode),
+		Detail: e.Message,
+	}
+}
+
+// WriteProblem sends e on w as an application/problem+json response.
+func (e *HandlerError) WriteProblem(w *response.Writer) error {
+	if err := w.WriteJSON(e.StatusCode, e.Problem()); err != nil {
+		return err
+	}
+	w.Headers().Set("Content-Type", "application/problem+json")
+	return nil
+}
+
+// prefersProblem reports whether req's Accept header weighs problem details
+// above plain text. A client asking for application/json is taken to
+// understand its problem+json variant too.
+func prefersProblem(req *request.Request) bool {
+	list := headers.ParseQualityList(req.Headers.Get("Accept"))
+	problem := max(weigh(list, "application/problem+json", matchMediaType), weigh(list, "application/json", matchMediaType))
+	return problem > weigh(list, "text/plain", matchMediaType)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		body         string
+		limit        int
+		expectStatus response.StatusCode
+	}{
+		{name: "Decoded", contentType: "application/json", body: `{"name":"Ada"}`},
+		{name: "Not JSON", contentType: "text/plain", body: `{"name":"Ada"}`, expectStatus: response.StatusUnsupportedMediaType},
+		{name: "Too large", contentType: "application/json", body: `{"name":"Ada"}`, limit: 4, expectStatus: response.StatusContentTooLarge},
+		{name: "Unknown field", contentType: "application/json", body: `{"nick":"Ada"}`, expectStatus: response.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &request.Request{
+				RequestLine:   request.RequestLine{Method: "POST", RequestTarget: "/", HttpVersion: "HTTP/1.1"},
+				Headers:       headers.Headers{"host": "localhost", "content-type": tc.contentType},
+				Body:          []byte(tc.body),
+				ContentLength: len(tc.body),
+			}
+
+			var v struct {
+				Name string `json:"name"`
+			}
+			handlerErr := DecodeJSON(req, &v, tc.limit)
+			if tc.expectStatus != 0 {
+				if handlerErr == nil || handlerErr.StatusCode != tc.expectStatus {
+					t.Fatalf("got error %v, want status %d", handlerErr, tc.expectStatus)
+				}
+				return
+			}
+			if handlerErr != nil {
+				t.Fatalf("unexpected error: %v", handlerErr.Message)
+			}
+			if v.Name != "Ada" {
+				t.Errorf("got name %q, want %q", v.Name, "Ada")
+			}
+		})
+	}
+}
+
+func TestPrefersProblem(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{accept: "", expected: false},
+		{accept: "application/problem+json", expected: true},
+		{accept: "application/json", expected: true},
+		{accept: "application/json, text/plain;q=0.5", expected: true},
+		{accept: "text/plain, application/json;q=0.5", expected: false},
+		{accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", expected: false},
+		{accept: "*/*", expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.accept, func(t *testing.T) {
+			req := &request.Request{Headers: headers.Headers{"accept": tc.accept}}
+			if got := prefersProblem(req); got != tc.expected {
+				t.Errorf("got %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestServerWritesProblem(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{StatusCode: response.StatusNotFound, Message: "no such user"}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /users/7 HTTP/1.1\r\nHost: localhost\r\nAccept: application/json\r\n\r\n")
+
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := response.ResponseFromReader(bytes.NewReader(raw), "GET")
+	if err != nil {
+		t.Fatalf("unexpected error: %v in %q", err, raw)
+	}
+	if resp.StatusLine.StatusCode != response.StatusNotFound {
+		t.Errorf("got status %d, want %d", resp.StatusLine.StatusCode, response.StatusNotFound)
+	}
+	if got := resp.Headers.Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("got Content-Type %q, want %q", got, "application/problem+json")
+	}
+
+	var problem Problem
+	if err := json.Unmarshal(resp.Body, &problem); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Problem{Title: "Not Found", Status: 404, Detail: "no such user"}
+	if problem != want {
+		t.Errorf("got %+v, want %+v", problem, want)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+// Negotiate returns the offered media type the request's Accept header
+// prefers, such as "application/json" or "text/html; charset=utf-8", and
+// adds Accept to Vary. Of the media ranges matching an offer, the most
+// specific one gives its weight, so "text/*;q=0.5, text/html" weighs
+// text/html at 1 and text/plain at 0.5. Offers weighed equally are chosen in
+// the order given. Without an Accept header the first offer is returned, and
+// when nothing offered is acceptable the HandlerError is a 406.
+func Negotiate(w *response.Writer, req *request.Request, offers ...string) (string, *HandlerError) {
+	return negotiate(w, req, "Accept", offers, matchMediaType)
+}
+
+// NegotiateLanguage is Negotiate for language tags, such as "en-GB", on
+// Accept-Language. A range matches a tag it is a prefix of, so "en" matches
+// "en-GB". Handlers that would rather fall back to a default language than
+// answer 406 can ignore the error and use it.
+func NegotiateLanguage(w *response.Writer, req *request.Request, offers ...string) (string, *HandlerError) {
+	return negotiate(w, req, "Accept-Language", offers, matchLanguage)
+}
+
+// NegotiateCharset is Negotiate for charsets, such as "utf-8", on
+// Accept-Charset.
+func NegotiateCharset(w *response.Writer, req *request.Request, offers ...string) (string, *HandlerError) {
+	return negotiate(w, req, "Accept-Charset", offers, matchCharset)
+}
+
+// A matcher reports how specifically the range rng, taken from an Accept
+// field, matches offer: -1 if it does not match and higher the more
+// specific the range is.
+type matcher func(rng, offer string) int
+
+func negotiate(w *response.Writer, req *request.Request, field string, offers []string, match matcher) (string, *HandlerError) {
+	addVary(w.Headers(), field)
+
+	// A field whose elements are all malformed is ignored, as if absent.
+	list := headers.ParseQualityList(req.Headers.Get(field))
+	if len(list) == 0 && len(offers) > 0 {
+		return offers[0], nil
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := weigh(list, offer, match); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if best == "" {
+		return "", &HandlerError{
+			StatusCode: response.StatusNotAcceptable,
+			Message:    fmt.Sprintf("%s allows none of: %s", field, strings.Join(offers, ", ")),
+		}
+	}
+	return best, nil
+}
+
+// weigh returns the weight list gives offer: that of the most specific
+// range matching it, or 0 if none does.
+func weigh(list []headers.QualityValue, offer string, match matcher) float64 {
+	q, specificity := 0.0, -1
+	for _, qv := range list {
+		if s := match(qv.Value, offer); s > specificity {
+			q, specificity = qv.Q, s
+		}
+	}
+	return q
+}
+
+// matchMediaType matches media ranges such as "*/*", "text/*", "text/html"
+// and "text/html;level=1". A range with parameters only matches offers
+// with the same parameters, and is more specific the more it has.
+func matchMediaType(rng, offer string) int {
+	rangeType, rangeParams, err := mime.ParseMediaType(rng)
+	if err != nil {
+		return -1
+	}
+	offerType, offerParams, err := mime.ParseMediaType(offer)
+	if err != nil {
+		return -1
+	}
+	rangeMain, rangeSub, ok := strings.Cut(rangeType, "/")
+	if !ok {
+		return -1
+	}
+	offerMain, offerSub, ok := strings.Cut(offerType, "/")
+	if !ok {
+		return -1
+	}
+
+	switch {
+	case rangeMain == "*" && rangeSub == "*":
+		return 0
+	case rangeMain != offerMain:
+		return -1
+	case rangeSub == "*":
+		return 1
+	case rangeSub != offerSub:
+		return -1
+	}
+	for name, value := range rangeParams {
+		if !strings.EqualFold(offerParams[name], value) {
+			return -1
+		}
+	}
+	return 2 + len(rangeParams)
+}
+
+// matchLanguage matches language ranges as RFC 4647 basic filtering does:
+// "*" matches every tag, and any other range matches a tag equal to it or
+// starting with it followed by "-".
+func matchLanguage(rng, offer string) int {
+	if rng == "*" {
+		return 0
+	}
+	if len(offer) < len(rng) || !strings.EqualFold(offer[:len(rng)], rng) {
+		return -1
+	}
+	if len(offer) > len(rng) && offer[len(rng)] != '-' {
+		return -1
+	}
+	return len(rng)
+}
+
+func matchCharset(rng, offer string) int {
+	switch {
+	case rng == "*":
+		return 0
+	case strings.EqualFold(rng, offer):
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+func TestNegotiate(t *testing.T) {
+	type negotiator func(w *response.Writer, req *request.Request, offers ...string) (string, *HandlerError)
+
+	tests := []struct {
+		name         string
+		negotiate    negotiator
+		field        string
+		accept       string
+		offers       []string
+		expected     string
+		expectStatus response.StatusCode
+	}{
+		{name: "No Accept", negotiate: Negotiate, field: "Accept", offers: []string{"application/json", "text/html"}, expected: "application/json"},
+		{name: "Exact", negotiate: Negotiate, field: "Accept", accept: "text/html", offers: []string{"application/json", "text/html"}, expected: "text/html"},
+		{name: "Browser", negotiate: Negotiate, field: "Accept", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", offers: []string{"application/json", "text/html"}, expected: "text/html"},
+		{name: "Weights", negotiate: Negotiate, field: "Accept", accept: "text/html;q=0.9, application/json", offers: []string{"text/html", "application/json"}, expected: "application/json"},
+		{name: "Server order breaks ties", negotiate: Negotiate, field: "Accept", accept: "application/json, text/html", offers: []string{"text/html", "application/json"}, expected: "text/html"},
+		{name: "Most specific range wins", negotiate: Negotiate, field: "Accept", accept: "text/*;q=0.5, text/html;q=0.1", offers: []string{"text/html", "text/plain"}, expected: "text/plain"},
+		{name: "Excluded by specific range", negotiate: Negotiate, field: "Accept", accept: "*/*, application/json;q=0", offers: []string{"application/json", "text/csv"}, expected: "text/csv"},
+		{name: "Offer with parameters", negotiate: Negotiate, field: "Accept", accept: "text/html", offers: []string{"text/html; charset=utf-8"}, expected: "text/html; charset=utf-8"},
+		{name: "Range with parameters", negotiate: Negotiate, field: "Accept", accept: "text/html;level=1, text/html;q=0.5", offers: []string{"text/html", "text/html;level=1"}, expected: "text/html;level=1"},
+		{name: "Case-insensitive", negotiate: Negotiate, field: "Accept", accept: "Application/JSON", offers: []string{"application/json"}, expected: "application/json"},
+		{name: "Malformed ignored", negotiate: Negotiate, field: "Accept", accept: "text/html;q=2", offers: []string{"application/json"}, expected: "application/json"},
+		{name: "Not acceptable", negotiate: Negotiate, field: "Accept", accept: "image/png", offers: []string{"application/json", "text/html"}, expectStatus: response.StatusNotAcceptable},
+		{name: "All refused", negotiate: Negotiate, field: "Accept", accept: "*/*;q=0", offers: []string{"application/json"}, expectStatus: response.StatusNotAcceptable},
+
+		{name: "Language prefix", negotiate: NegotiateLanguage, field: "Accept-Language", accept: "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", offers: []string{"en-GB", "fr-FR", "de"}, expected: "fr-FR"},
+		{name: "Language exact beats prefix", negotiate: NegotiateLanguage, field: "Accept-Language", accept: "en;q=0.5, en-GB", offers: []string{"en-US", "en-GB"}, expected: "en-GB"},
+		{name: "Language not a subtag", negotiate: NegotiateLanguage, field: "Accept-Language", accept: "en", offers: []string{"eng"}, expectStatus: response.StatusNotAcceptable},
+		{name: "Language wildcard", negotiate: NegotiateLanguage, field: "Accept-Language", accept: "de, *;q=0.1", offers: []string{"nl"}, expected: "nl"},
+
+		{name: "Charset", negotiate: NegotiateCharset, field: "Accept-Charset", accept: "iso-8859-1;q=0.5, UTF-8", offers: []string{"iso-8859-1", "utf-8"}, expected: "utf-8"},
+		{name: "Charset refused", negotiate: NegotiateCharset, field: "Accept-Charset", accept: "utf-8, *;q=0", offers: []string{"shift_jis"}, expectStatus: response.StatusNotAcceptable},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &request.Request{
+				RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "HTTP/1.1"},
+				Headers:     headers.Headers{"host": "localhost"},
+			}
+			if tc.accept != "" {
+				req.Headers.Set(tc.field, tc.accept)
+			}
+			w := response.NewWriter(&bytes.Buffer{})
+
+			got, handlerErr := tc.negotiate(w, req, tc.offers...)
+			if got := w.Headers().Get("Vary"); got != tc.field {
+				t.Errorf("got Vary %q, want %q", got, tc.field)
+			}
+			if tc.expectStatus != 0 {
+				if handlerErr == nil || handlerErr.StatusCode != tc.expectStatus {
+					t.Fatalf("got %q and error %v, want status %d", got, handlerErr, tc.expectStatus)
+				}
+				return
+			}
+			if handlerErr != nil {
+				t.Fatalf("unexpected error: %v", handlerErr.Message)
+			}
+			if got != tc.expected {
+				t.Errorf("got %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
@@ -1,19 +1,26 @@
 package server
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"net"
-	"strconv"
 	"sync/atomic"
 
 	"github.com/portbound/tcp-to-http/internal/request"
 	"github.com/portbound/tcp-to-http/internal/response"
 )
 
+// Server serves a Handler over TCP. Serve starts one with the default
+// settings; to change them, set the fields and call Listen.
 type Server struct {
-	handler  Handler
+	// Handler answers every request.
+	Handler Handler
+	// ErrorRenderer renders the errors Handler returns and the 400, 417, 431
+	// and 501 responses to requests that cannot be parsed. Nil means
+	// DefaultErrorRenderer.
+	ErrorRenderer ErrorRenderer
+
 	listener net.Listener
 	closed   atomic.Bool
 }
@@ -33,64 +40,111 @@ func (s *Server) listen() {
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+	render := s.ErrorRenderer
+	if render == nil {
+		render = DefaultErrorRenderer
+	}
 
 	req, err := request.RequestFromReader(conn)
 	if err != nil {
-		handlerErr := HandlerError{
+		defer conn.Close()
+		handlerErr := &HandlerError{
 			StatusCode: response.StatusBadRequest,
 			Message:    err.Error(),
 		}
-		handlerErr.Write(conn)
+		switch {
+		case errors.Is(err, request.ErrUnsupportedExpectation):
+			handlerErr.StatusCode = response.StatusExpectationFailed
+		case errors.Is(err, request.ErrHeaderTooLarge):
+			handlerErr.StatusCode = response.StatusHeaderFieldsTooLarge
+		case errors.Is(err, request.ErrUnsupportedTransferEncoding):
+			handlerErr.StatusCode = response.StatusNotImplemented
+		}
+		w := response.NewWriter(conn)
+		renderError(w, nil, handlerErr, render)
+		if err := w.Close(); err != nil {
+			log.Printf("error: %v", err)
+		}
 		return
 	}
 
-	buf := bytes.NewBuffer([]byte{})
-	handlerErr := s.handler(buf, req)
-	if handlerErr != nil {
-		handlerErr.Write(conn)
-		return
-	}
+	req.RemoteAddr = conn.RemoteAddr().String()
 
-	err = response.WriteStatusLine(buf, response.StatusOk)
-	if err != nil {
-		log.Printf("error: %v", err)
-		return
+	req.Continue = func() error {
+		return response.WriteContinue(conn)
 	}
 
-	contentLen, err := strconv.Atoi(req.Headers.Get("Content-Length"))
-	if err != nil {
-		log.Printf("error: %v", err)
+	w := response.NewWriter(conn)
+	// A HEAD response is the head a GET would get, so handlers can treat
+	// the two alike and the body they write is measured but never sent.
+	if req.RequestLine.Method == "HEAD" {
+		w.OmitBody()
+	}
+	w.SetHijacker(func() (net.Conn, []byte, error) {
+		return conn, req.Buffered(), nil
+	})
+	handlerErr := s.Handler(w, req)
+	// A hijacked connection belongs to the handler now, and so does req,
+	// which it may still be using: it is left to the garbage collector
+	// rather than returned to the pool.
+	if w.Hijacked() {
+		if handlerErr != nil {
+			log.Printf("error after hijack: %s", handlerErr.Message)
+		}
 		return
 	}
+	defer conn.Close()
+	// The handler may have kept req's headers or body, so only the read
+	// buffer is reused.
+	defer req.ReleaseBuffer()
+	if req.MultipartForm != nil {
+		defer req.MultipartForm.RemoveAll()
+	}
 
-	defaultHeaders := response.GetDefaultHeaders(contentLen)
+	if handlerErr != nil {
+		if w.HeadWritten() {
+			log.Printf("error after response started: %s", handlerErr.Message)
+			return
+		}
+		renderError(w, req, handlerErr, render)
+	}
 
-	err = response.WriteHeaders(buf, defaultHeaders)
-	if err != nil {
+	if err := w.Close(); err != nil {
 		log.Printf("error: %v", err)
-		return
 	}
+}
 
-	conn.Write([]byte("\r\n"))
+// Addr returns the address the server is listening on, which tells callers
+// the port when Serve was given port 0.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
 }
 
 func (s *Server) Close() error {
+	s.closed.Store(true)
 	if err := s.listener.Close(); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Serve listens on port and serves handler with the default settings.
 func Serve(port int, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
+	s := &Server{Handler: handler}
+	if err := s.Listen(port); err != nil {
 		return nil, err
 	}
-	s := Server{
-		handler:  handler,
-		listener: listener,
+	return s, nil
+}
+
+// Listen starts serving s.Handler on port. The fields must not change
+// afterwards.
+func (s *Server) Listen(port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
 	}
+	s.listener = listener
 	go s.listen()
-	return &s, nil
+	return nil
 }
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+func TestServerHijack(t *testing.T) {
+	// The handler answers a CONNECT-style request itself, then keeps the
+	// connection after returning, with an error the server must not render.
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) *HandlerError {
+		io.WriteString(w, "never sent")
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+		}
+		go func() {
+			defer conn.Close()
+			fmt.Fprintf(conn, "HTTP/1.1 200 Connection Established\r\n\r\nearly: %s", buffered)
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			fmt.Fprintf(conn, "late: %s", line)
+		}()
+		return &HandlerError{StatusCode: response.StatusInternalServerError, Message: "ignored"}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\nhello\n")
+
+	br := bufio.NewReader(conn)
+	want := "HTTP/1.1 200 Connection Established\r\n\r\nearly: hello\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(br, got); err != nil {
+		t.Fatalf("unexpected error: %v in %q", err, got)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	fmt.Fprint(conn, "world\n")
+	rest, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(rest) != "late: world\n" {
+		t.Errorf("got %q, want %q", rest, "late: world\n")
+	}
+}
+
+func TestServerErrorRenderer(t *testing.T) {
+	s := &Server{
+		Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+			return &HandlerError{StatusCode: response.StatusNotFound}
+		},
+		ErrorRenderer: func(w *response.Writer, req *request.Request, e *HandlerError) {
+			w.SetStatusCode(e.StatusCode)
+			w.Headers().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, "<h1>%d</h1>", e.StatusCode)
+		},
+	}
+	if err := s.Listen(0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		name         string
+		request      string
+		expectStatus response.StatusCode
+	}{
+		{name: "Handler error", request: "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", expectStatus: response.StatusNotFound},
+		{name: "Unparsable request", request: "GET /\r\n\r\n", expectStatus: response.StatusBadRequest},
+		{
+			name:         "Unsupported expectation",
+			request:      "POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 102-processing\r\nContent-Length: 1\r\n\r\nx",
+			expectStatus: response.StatusExpectationFailed,
+		},
+		{
+			name:         "Chunked body",
+			request:      "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nx\r\n0\r\n\r\n",
+			expectStatus: response.StatusNotImplemented,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", s.Addr().String())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer conn.Close()
+			io.WriteString(conn, tc.request)
+
+			resp, err := response.ResponseFromReader(conn, "GET")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusLine.StatusCode != tc.expectStatus {
+				t.Errorf("got status %d, want %d", resp.StatusLine.StatusCode, tc.expectStatus)
+			}
+			if want := fmt.Sprintf("<h1>%d</h1>", tc.expectStatus); string(resp.Body) != want {
+				t.Errorf("got body %q, want %q", resp.Body, want)
+			}
+		})
+	}
+}
+
+func TestServerHijackKeepsRequest(t *testing.T) {
+	release := make(chan struct{})
+	seen := make(chan string, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) *HandlerError {
+		if req.RequestLine.RequestTarget != "/hijack" {
+			io.WriteString(w, "ok")
+			return nil
+		}
+		conn, _, err := w.Hijack()
+		if err != nil {
+			return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+		}
+		go func() {
+			defer conn.Close()
+			// By now other requests have been served, and would have reused
+			// req had it gone back to the pool.
+			<-release
+			body, _ := req.ReadBody()
+			seen <- fmt.Sprintf("%s %s %s", req.RequestLine.RequestTarget, req.Headers.Get("X-Id"), body)
+		}()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "POST /hijack HTTP/1.1\r\nHost: localhost\r\nX-Id: first\r\nContent-Length: 4\r\n\r\nbody")
+
+	for i := range 20 {
+		other, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fmt.Fprintf(other, "POST /other-%02d HTTP/1.1\r\nHost: localhost\r\nX-Id: other\r\nContent-Length: 4\r\n\r\nxxxx", i)
+		io.ReadAll(other)
+		other.Close()
+	}
+
+	close(release)
+	if got, want := <-seen, "/hijack first body"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestServerHandlerKeepsRequest(t *testing.T) {
+	kept := make(chan *request.Request, 2)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) *HandlerError {
+		kept <- req
+		io.WriteString(w, "ok")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	for _, body := range []string{"FIRST-BODY", "SECOND-BOD"} {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nX-Body: %s\r\nContent-Length: %d\r\n\r\n%s", body, len(body), body)
+		io.ReadAll(conn)
+		conn.Close()
+	}
+
+	for _, want := range []string{"FIRST-BODY", "SECOND-BOD"} {
+		req := <-kept
+		if got := string(req.Body); got != want {
+			t.Errorf("got body %q, want %q", got, want)
+		}
+		if got := req.Headers.Get("X-Body"); got != want {
+			t.Errorf("got header %q, want %q", got, want)
+		}
+	}
+}
+
+func TestServerHeadOmitsBody(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) *HandlerError {
+		io.WriteString(w, "All good, frfr\n")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "HTTP/1.1 200 OK\r\n" +
+		"Connection: close\r\n" +
+		"Content-Length: 15\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n"
+	if string(raw) != want {
+		t.Errorf("got\n%q\nwant\n%q", raw, want)
+	}
+}
+
+func TestServerRemovesUploads(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	spilled := make(chan int, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) *HandlerError {
+		if err := req.ParseMultipartForm(request.MultipartLimits{MaxMemory: 4}); err != nil {
+			return &HandlerError{StatusCode: response.StatusBadRequest, Message: err.Error()}
+		}
+		entries, _ := os.ReadDir(tmp)
+		spilled <- len(entries)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	body := "--x\r\n" +
+		"Content-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n" +
+		"\r\n" +
+		"more than four bytes\r\n" +
+		"--x--\r\n"
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: multipart/form-data; boundary=x\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	io.ReadAll(conn)
+
+	if got := <-spilled; got != 1 {
+		t.Fatalf("got %d temporary files during the handler, want 1", got)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("left %d temporary files behind", len(entries))
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+// HostRouter picks a Handler by the request's Host header. Patterns are
+// either exact host names ("docs.internal") or wildcard subdomains
+// ("*.tools.internal"). Matching ignores case and the port, and the most
+// specific pattern wins.
+type HostRouter struct {
+	// Default handles hosts that match no pattern. When nil, such
+	// requests get a 404.
+	Default Handler
+
+	hosts     map[string]Handler
+	wildcards map[string]Handler
+}
+
+func NewHostRouter() *HostRouter {
+	return &HostRouter{
+		hosts:     make(map[string]Handler),
+		wildcards: make(map[string]Handler),
+	}
+}
+
+// Handle registers handler for pattern. A pattern of the form "*.example.com"
+// matches any subdomain of example.com, but not example.com itself.
+func (hr *HostRouter) Handle(pattern string, handler Handler) {
+	pattern = normalizeHost(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		hr.wildcards[suffix] = handler
+		return
+	}
+	hr.hosts[pattern] = handler
+}
+
+// Serve dispatches req to the handler registered for its host. Pass it to
+// Serve as the server's Handler.
+func (hr *HostRouter) Serve(w *response.Writer, req *request.Request) *HandlerError {
+	host := normalizeHost(req.Headers.Get("Host"))
+
+	if handler := hr.match(host); handler != nil {
+		return handler(w, req)
+	}
+
+	if hr.Default != nil {
+		return hr.Default(w, req)
+	}
+
+	return &HandlerError{
+		StatusCode: response.StatusNotFound,
+		Message:    fmt.Sprintf("no handler for host %q", host),
+	}
+}
+
+func (hr *HostRouter) match(host string) Handler {
+	if handler, ok := hr.hosts[host]; ok {
+		return handler
+	}
+
+	// Walk up the labels so "a.b.example.com" tries "b.example.com" before
+	// "example.com".
+	for rest := host; ; {
+		_, parent, ok := strings.Cut(rest, ".")
+		if !ok {
+			return nil
+		}
+		if handler, ok := hr.wildcards[parent]; ok {
+			return handler
+		}
+		rest = parent
+	}
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimPrefix(host, "[")
+	host = strings.TrimSuffix(host, "]")
+	host = strings.TrimSuffix(host, ".")
+	return strings.ToLower(host)
+}
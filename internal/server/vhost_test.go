@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+)
+
+func named(name string) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		io.WriteString(w, name)
+		return nil
+	}
+}
+
+func TestHostRouter(t *testing.T) {
+	router := NewHostRouter()
+	router.Handle("docs.internal", named("docs"))
+	router.Handle("*.tools.internal", named("tools"))
+	router.Handle("*.ci.tools.internal", named("ci"))
+	router.Handle("[::1]", named("loopback"))
+
+	tests := []struct {
+		name         string
+		host         string
+		withDefault  bool
+		expectBody   string
+		expectStatus response.StatusCode
+	}{
+		{name: "Exact host", host: "docs.internal", expectBody: "docs"},
+		{name: "Port and case ignored", host: "DOCS.internal:42069", expectBody: "docs"},
+		{name: "Wildcard subdomain", host: "grafana.tools.internal", expectBody: "tools"},
+		{name: "Nested wildcard subdomain", host: "a.b.tools.internal", expectBody: "tools"},
+		{name: "Most specific wildcard", host: "runner.ci.tools.internal", expectBody: "ci"},
+		{name: "Wildcard excludes apex", host: "tools.internal", expectStatus: response.StatusNotFound},
+		{name: "IPv6 literal", host: "[::1]:8080", expectBody: "loopback"},
+		{name: "Unknown host", host: "example.com", expectStatus: response.StatusNotFound},
+		{name: "Unknown host with default", host: "example.com", withDefault: true, expectBody: "default"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			router.Default = nil
+			if tc.withDefault {
+				router.Default = named("default")
+			}
+
+			req := &request.Request{Headers: headers.Headers{"host": tc.host}}
+			buf := &bytes.Buffer{}
+			w := response.NewWriter(buf)
+			handlerErr := router.Serve(w, req)
+
+			if tc.expectStatus != 0 {
+				if handlerErr == nil || handlerErr.StatusCode != tc.expectStatus {
+					t.Fatalf("got error %v, want status %d", handlerErr, tc.expectStatus)
+				}
+				return
+			}
+
+			if handlerErr != nil {
+				t.Fatalf("unexpected error: %v", handlerErr)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp, err := response.ResponseFromReader(buf, "GET")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(resp.Body) != tc.expectBody {
+				t.Errorf("got body %q, want %q", resp.Body, tc.expectBody)
+			}
+		})
+	}
+}
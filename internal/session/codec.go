@@ -0,0 +1,146 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// minSecretSize is the shortest secret New accepts, enough for HMAC-SHA256
+// and AES-256 keys derived from it to be full strength.
+const minSecretSize = 32
+
+// maxCookieSize is the longest cookie value saved. Browsers keep cookies of
+// at least 4096 bytes including the name and attributes.
+const maxCookieSize = 3800
+
+var (
+	// ErrInvalidSecret is returned by New for a missing or short secret.
+	ErrInvalidSecret = errors.New("invalid session secret")
+	// ErrCookieTooLarge is returned by Save when a session's values do not
+	// fit in a cookie. A Store removes the limit.
+	ErrCookieTooLarge = errors.New("session cookie too large")
+
+	errInvalidCookie = errors.New("invalid session cookie")
+)
+
+// payload is what a session cookie carries: its expiry and either its
+// values or, with a Store, its ID.
+type payload struct {
+	Expires int64             `json:"e"`
+	ID      string            `json:"i,omitempty"`
+	Values  map[string]string `json:"v,omitempty"`
+}
+
+// keys are the signing key and the encryption cipher derived from one
+// secret.
+type keys struct {
+	sign []byte
+	aead cipher.AEAD
+}
+
+// codec turns payloads into cookie values and back. A cookie value is the
+// base64url payload, sealed when encrypted, then "." and its base64url
+// HMAC. The MAC also covers the cookie name, so a value cannot be moved to
+// another cookie protected by the same secrets.
+type codec struct {
+	keys []keys
+}
+
+func newCodec(secrets [][]byte) (*codec, error) {
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("%w: none given", ErrInvalidSecret)
+	}
+	c := &codec{}
+	for i, secret := range secrets {
+		if len(secret) < minSecretSize {
+			return nil, fmt.Errorf("%w: secret %d is %d bytes, want at least %d", ErrInvalidSecret, i, len(secret), minSecretSize)
+		}
+		block, err := aes.NewCipher(derive(secret, "encrypt"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys = append(c.keys, keys{sign: derive(secret, "sign"), aead: aead})
+	}
+	return c, nil
+}
+
+// derive returns a 32-byte key for purpose, so the signing and encryption
+// keys from one secret are independent.
+func derive(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (c *codec) encode(name string, data payload, encrypt bool) (string, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	k := c.keys[0]
+	if encrypt {
+		nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(b)+k.aead.Overhead())
+		rand.Read(nonce)
+		b = k.aead.Seal(nonce, nonce, b, []byte(name))
+	}
+
+	body := base64.RawURLEncoding.EncodeToString(b)
+	return body + "." + base64.RawURLEncoding.EncodeToString(sign(k.sign, name, body)), nil
+}
+
+// decode verifies value against each key in turn and returns its payload.
+// A value that fails to decrypt is treated as plain, so turning Encrypt on
+// does not invalidate existing sessions.
+func (c *codec) decode(name, value string) (payload, error) {
+	body, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return payload{}, errInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return payload{}, errInvalidCookie
+	}
+	b, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return payload{}, errInvalidCookie
+	}
+
+	for _, k := range c.keys {
+		if subtle.ConstantTimeCompare(mac, sign(k.sign, name, body)) != 1 {
+			continue
+		}
+		if n := k.aead.NonceSize(); len(b) > n {
+			if plain, err := k.aead.Open(nil, b[:n], b[n:], []byte(name)); err == nil {
+				b = plain
+			}
+		}
+		var data payload
+		if err := json.Unmarshal(b, &data); err != nil {
+			return payload{}, errInvalidCookie
+		}
+		return data, nil
+	}
+	return payload{}, errInvalidCookie
+}
+
+func sign(key []byte, name, body string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{'|'})
+	mac.Write([]byte(body))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,262 @@
+package session
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+	"github.com/portbound/tcp-to-http/internal/server"
+)
+
+const (
+	defaultCookieName = "session"
+	defaultMaxAge     = 24 * time.Hour
+)
+
+// ErrNotFound is returned by a Store for an unknown or expired session.
+var ErrNotFound = errors.New("session not found")
+
+// Session is the data kept for one client across requests. It is not safe
+// for concurrent use, and changes are saved only when the response head has
+// not been sent yet; call Manager.Save before streaming a response.
+type Session struct {
+	values  map[string]string
+	id      string
+	expires time.Time
+
+	// oldID is the stored session Renew replaced, deleted on save.
+	oldID     string
+	modified  bool
+	destroyed bool
+}
+
+// Get returns the value stored under key, or "" if there is none.
+func (s *Session) Get(key string) string {
+	return s.values[key]
+}
+
+// Set stores value under key.
+func (s *Session) Set(key, value string) {
+	if s.values == nil {
+		s.values = make(map[string]string)
+	}
+	s.values[key] = value
+	s.modified = true
+}
+
+// Delete removes key.
+func (s *Session) Delete(key string) {
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// IsNew reports whether the session started with this request rather than
+// being loaded from a cookie.
+func (s *Session) IsNew() bool {
+	return s.expires.IsZero()
+}
+
+// Renew keeps the session's values under a new identity and expiry. Call it
+// when the privileges behind a session change, such as at login, so an
+// identifier planted on the client beforehand is worthless.
+func (s *Session) Renew() {
+	if s.id != "" && s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = ""
+	s.expires = time.Time{}
+	s.modified = true
+}
+
+// Destroy deletes the session's values and, once saved, its cookie.
+func (s *Session) Destroy() {
+	clear(s.values)
+	s.destroyed = true
+}
+
+// Manager loads and saves sessions in cookies. The cookie is signed with
+// HMAC-SHA256, and with Encrypt set its content is also sealed with
+// AES-256-GCM so the client cannot read it. With a Store, the cookie holds
+// only a random session ID and the values are kept server-side.
+type Manager struct {
+	// CookieName defaults to "session".
+	CookieName string
+	// MaxAge is how long a session lasts after it was last saved. Zero
+	// means 24 hours.
+	MaxAge time.Duration
+	// Path, Domain, Secure and SameSite set the cookie attributes of the
+	// same name. The cookie is always HttpOnly.
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite response.SameSite
+	// Encrypt hides the cookie's content from the client.
+	Encrypt bool
+	// Store keeps session values server-side. When nil they are kept in
+	// the cookie itself, which limits them to about 3 KiB.
+	Store Store
+
+	codec    *codec
+	sessions sync.Map // *request.Request to *Session
+}
+
+// New returns a Manager keeping sessions in cookies protected by secrets,
+// each of at least 32 random bytes. The first secret protects new cookies,
+// and any of them is accepted when reading one, so a secret is rotated by
+// putting a new one first and dropping the old one once the longest lived
+// cookie protected by it has expired.
+func New(secrets ...[]byte) (*Manager, error) {
+	c, err := newCodec(secrets)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{Path: "/", SameSite: response.SameSiteLax, codec: c}, nil
+}
+
+// Wrap loads the session of each request before calling next, which gets it
+// with Get, and saves it afterwards if it changed.
+func (m *Manager) Wrap(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		s := m.load(req)
+		m.sessions.Store(req, s)
+		defer m.sessions.Delete(req)
+
+		if handlerErr := next(w, req); handlerErr != nil {
+			return handlerErr
+		}
+		if w.HeadWritten() || w.Hijacked() {
+			if s.modified || s.destroyed {
+				log.Printf("session changed after response started, not saved")
+			}
+			return nil
+		}
+		if err := m.Save(w, req); err != nil {
+			return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+		}
+		return nil
+	}
+}
+
+// Get returns the session of a request being served through Wrap, or nil
+// for any other request.
+func (m *Manager) Get(req *request.Request) *Session {
+	s, ok := m.sessions.Load(req)
+	if !ok {
+		return nil
+	}
+	return s.(*Session)
+}
+
+// Save writes a changed session to the store and sets its cookie on w. Wrap
+// calls it once the handler returns; handlers call it themselves before
+// flushing a response.
+func (m *Manager) Save(w *response.Writer, req *request.Request) error {
+	s := m.Get(req)
+	if s == nil || !(s.modified || s.destroyed) {
+		return nil
+	}
+
+	if s.oldID != "" && m.Store != nil {
+		if err := m.Store.Delete(s.oldID); err != nil {
+			return err
+		}
+		s.oldID = ""
+	}
+
+	if s.destroyed {
+		if s.id != "" && m.Store != nil {
+			if err := m.Store.Delete(s.id); err != nil {
+				return err
+			}
+		}
+		s.modified, s.destroyed = false, false
+		return w.SetCookie(m.cookie("", -1))
+	}
+
+	s.expires = time.Now().Add(m.maxAge()).Truncate(time.Second)
+	data := payload{Expires: s.expires.Unix()}
+	if m.Store != nil {
+		if s.id == "" {
+			s.id = rand.Text()
+		}
+		if err := m.Store.Save(s.id, s.values, s.expires); err != nil {
+			return err
+		}
+		data.ID = s.id
+	} else {
+		data.Values = s.values
+	}
+
+	value, err := m.codec.encode(m.cookieName(), data, m.Encrypt)
+	if err != nil {
+		return err
+	}
+	if len(value) > maxCookieSize {
+		return fmt.Errorf("%w: %d bytes", ErrCookieTooLarge, len(value))
+	}
+	s.modified = false
+	return w.SetCookie(m.cookie(value, int(m.maxAge()/time.Second)))
+}
+
+// load returns the session named by the request's cookie, or a new empty
+// one if the cookie is missing, forged, expired or no longer stored.
+func (m *Manager) load(req *request.Request) *Session {
+	c, err := req.Cookie(m.cookieName())
+	if err != nil {
+		return &Session{}
+	}
+	data, err := m.codec.decode(m.cookieName(), c.Value)
+	if err != nil {
+		return &Session{}
+	}
+	expires := time.Unix(data.Expires, 0)
+	if !time.Now().Before(expires) {
+		return &Session{}
+	}
+
+	if m.Store == nil {
+		return &Session{values: data.Values, expires: expires}
+	}
+	values, err := m.Store.Load(data.ID)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Printf("error loading session: %v", err)
+		}
+		return &Session{}
+	}
+	return &Session{values: values, id: data.ID, expires: expires}
+}
+
+func (m *Manager) cookie(value string, maxAge int) response.Cookie {
+	return response.Cookie{
+		Name:     m.cookieName(),
+		Value:    value,
+		MaxAge:   maxAge,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+	}
+}
+
+func (m *Manager) cookieName() string {
+	if m.CookieName == "" {
+		return defaultCookieName
+	}
+	return m.CookieName
+}
+
+func (m *Manager) maxAge() time.Duration {
+	if m.MaxAge <= 0 {
+		return defaultMaxAge
+	}
+	return m.MaxAge
+}
@@ -0,0 +1,226 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+	"github.com/portbound/tcp-to-http/internal/server"
+)
+
+var (
+	secretA = []byte(strings.Repeat("a", 32))
+	secretB = []byte(strings.Repeat("b", 32))
+)
+
+// serve runs h through m for a request carrying cookie, a "name=value"
+// pair, and returns the Set-Cookie field it answered with.
+func serve(t *testing.T, m *Manager, cookie string, h server.Handler) string {
+	t.Helper()
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "HTTP/1.1"},
+		Headers:     headers.Headers{"host": "localhost"},
+	}
+	if cookie != "" {
+		req.Headers.Set("Cookie", cookie)
+	}
+	w := response.NewWriter(&bytes.Buffer{})
+	if handlerErr := m.Wrap(h)(w, req); handlerErr != nil {
+		t.Fatalf("unexpected error: %v", handlerErr.Message)
+	}
+	return w.Headers().Get("Set-Cookie")
+}
+
+// pair returns the "name=value" part of a Set-Cookie field.
+func pair(setCookie string) string {
+	p, _, _ := strings.Cut(setCookie, ";")
+	return p
+}
+
+func set(m *Manager, key, value string) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		m.Get(req).Set(key, value)
+		return nil
+	}
+}
+
+func read(m *Manager, got *string) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		*got = m.Get(req).Get("user")
+		return nil
+	}
+}
+
+func TestManager(t *testing.T) {
+	tests := []struct {
+		name    string
+		encrypt bool
+		store   bool
+	}{
+		{name: "Signed"},
+		{name: "Encrypted", encrypt: true},
+		{name: "Stored", store: true},
+		{name: "Stored and encrypted", encrypt: true, store: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := New(secretA)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			m.Encrypt = tc.encrypt
+			if tc.store {
+				m.Store = NewMemoryStore()
+			}
+
+			setCookie := serve(t, m, "", set(m, "user", "ada"))
+			for _, attr := range []string{"session=", "Path=/", "Max-Age=86400", "HttpOnly", "SameSite=Lax"} {
+				if !strings.Contains(setCookie, attr) {
+					t.Errorf("got Set-Cookie %q, want it to contain %q", setCookie, attr)
+				}
+			}
+			if tc.encrypt {
+				body, _, _ := strings.Cut(strings.TrimPrefix(pair(setCookie), "session="), ".")
+				if strings.HasPrefix(body, "eyJ") {
+					t.Errorf("got readable cookie %q", body)
+				}
+			}
+
+			var user string
+			if got := serve(t, m, pair(setCookie), read(m, &user)); got != "" {
+				t.Errorf("got Set-Cookie %q for an unchanged session", got)
+			}
+			if user != "ada" {
+				t.Errorf("got user %q, want %q", user, "ada")
+			}
+		})
+	}
+}
+
+func TestManagerRejects(t *testing.T) {
+	m, _ := New(secretA)
+	valid := pair(serve(t, m, "", set(m, "user", "ada")))
+
+	value, err := m.codec.encode("session", payload{Expires: time.Now().Add(-time.Minute).Unix(), Values: map[string]string{"user": "ada"}}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	old := "session=" + value
+
+	other, _ := New(secretB)
+	forged := pair(serve(t, other, "", set(other, "user", "ada")))
+
+	renamed := "other" + strings.TrimPrefix(valid, "session")
+	renamedManager, _ := New(secretA)
+	renamedManager.CookieName = "other"
+
+	tests := []struct {
+		name    string
+		manager *Manager
+		cookie  string
+	}{
+		{name: "Tampered", manager: m, cookie: valid[:len(valid)-2] + "AA"},
+		{name: "Other secret", manager: m, cookie: forged},
+		{name: "Other cookie name", manager: renamedManager, cookie: renamed},
+		{name: "Malformed", manager: m, cookie: "session=garbage"},
+		{name: "Expired", manager: m, cookie: old},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			user := "unset"
+			serve(t, tc.manager, tc.cookie, read(tc.manager, &user))
+			if user != "" {
+				t.Errorf("got user %q from a rejected cookie", user)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	before, _ := New(secretA)
+	cookie := pair(serve(t, before, "", set(before, "user", "ada")))
+
+	after, err := New(secretB, secretA)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var user string
+	serve(t, after, cookie, read(after, &user))
+	if user != "ada" {
+		t.Errorf("got user %q from a cookie signed with the old secret, want %q", user, "ada")
+	}
+
+	rotated := pair(serve(t, after, "", set(after, "user", "ada")))
+	serve(t, before, rotated, read(before, &user))
+	if user != "" {
+		t.Errorf("got user %q, want new cookies signed with the new secret", user)
+	}
+}
+
+func TestDestroyAndRenew(t *testing.T) {
+	store := NewMemoryStore()
+	m, _ := New(secretA)
+	m.Store = store
+
+	cookie := pair(serve(t, m, "", set(m, "user", "ada")))
+
+	renewed := pair(serve(t, m, cookie, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		m.Get(req).Renew()
+		return nil
+	}))
+	if renewed == cookie {
+		t.Fatal("Renew kept the session ID")
+	}
+	if store.Len() != 1 {
+		t.Errorf("got %d stored sessions, want the old one deleted", store.Len())
+	}
+	var user string
+	serve(t, m, cookie, read(m, &user))
+	if user != "" {
+		t.Errorf("got user %q from the renewed-away session", user)
+	}
+	serve(t, m, renewed, read(m, &user))
+	if user != "ada" {
+		t.Errorf("got user %q, want %q", user, "ada")
+	}
+
+	setCookie := serve(t, m, renewed, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		m.Get(req).Destroy()
+		return nil
+	})
+	if !strings.HasPrefix(setCookie, "session=;") || !strings.Contains(setCookie, "Max-Age=0") {
+		t.Errorf("got Set-Cookie %q, want the cookie deleted", setCookie)
+	}
+	if store.Len() != 0 {
+		t.Errorf("got %d stored sessions, want none", store.Len())
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(); !errors.Is(err, ErrInvalidSecret) {
+		t.Errorf("got error %v with no secret, want %v", err, ErrInvalidSecret)
+	}
+	if _, err := New(secretA, []byte("short")); !errors.Is(err, ErrInvalidSecret) {
+		t.Errorf("got error %v with a short secret, want %v", err, ErrInvalidSecret)
+	}
+}
+
+func TestCookieTooLarge(t *testing.T) {
+	m, _ := New(secretA)
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "HTTP/1.1"},
+		Headers:     headers.Headers{"host": "localhost"},
+	}
+	w := response.NewWriter(&bytes.Buffer{})
+	handlerErr := m.Wrap(set(m, "big", strings.Repeat("x", 4096)))(w, req)
+	if handlerErr == nil || handlerErr.StatusCode != response.StatusInternalServerError {
+		t.Fatalf("got %v, want a 500 error", handlerErr)
+	}
+}
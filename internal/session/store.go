@@ -0,0 +1,88 @@
+package session
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// Store keeps session values server-side, keyed by session ID.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Load returns the values saved under id, or ErrNotFound if there are
+	// none or they have expired.
+	Load(id string) (map[string]string, error)
+	// Save replaces the values under id, to be kept until expires.
+	Save(id string, values map[string]string, expires time.Time) error
+	// Delete removes id. Deleting an unknown id is not an error.
+	Delete(id string) error
+}
+
+// MemoryStore is a Store in process memory, for a single server whose
+// sessions may be lost on restart. Expired sessions are dropped as they are
+// found and by an occasional sweep during Save.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	lastSweep time.Time
+}
+
+type memorySession struct {
+	values  map[string]string
+	expires time.Time
+}
+
+// sweepInterval is how often Save scans for expired sessions.
+const sweepInterval = time.Minute
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]memorySession)}
+}
+
+func (ms *MemoryStore) Load(id string) (map[string]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, ok := ms.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !time.Now().Before(s.expires) {
+		delete(ms.sessions, id)
+		return nil, ErrNotFound
+	}
+	return maps.Clone(s.values), nil
+}
+
+func (ms *MemoryStore) Save(id string, values map[string]string, expires time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(ms.lastSweep) >= sweepInterval {
+		for id, s := range ms.sessions {
+			if !now.Before(s.expires) {
+				delete(ms.sessions, id)
+			}
+		}
+		ms.lastSweep = now
+	}
+	ms.sessions[id] = memorySession{values: maps.Clone(values), expires: expires}
+	return nil
+}
+
+func (ms *MemoryStore) Delete(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.sessions, id)
+	return nil
+}
+
+// Len returns the number of sessions held, including expired ones not yet
+// dropped.
+func (ms *MemoryStore) Len() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return len(ms.sessions)
+}
@@ -0,0 +1,558 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// Close codes, from RFC 6455 section 7.4.1. CloseNoStatus and CloseAbnormal
+// are only ever reported, never sent.
+const (
+	CloseNormal             = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatus           = 1005
+	CloseAbnormal           = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsv2Bit = 0x20
+	rsv3Bit = 0x10
+	maskBit = 0x80
+
+	maxControlPayload = 125
+	defaultReadLimit  = 1 << 20
+
+	// writeFrameSize is the payload size a message written through
+	// NextWriter is fragmented into.
+	writeFrameSize = 4096
+)
+
+var (
+	// ErrProtocol is returned for a frame that breaks RFC 6455. The
+	// connection is closed with CloseProtocolError.
+	ErrProtocol = errors.New("websocket: protocol error")
+	// ErrMessageTooBig is returned for a message over the read limit. The
+	// connection is closed with CloseMessageTooBig.
+	ErrMessageTooBig = errors.New("websocket: message too big")
+	// ErrInvalidPayload is returned for a text message that is not UTF-8 or
+	// a compressed message that does not inflate. The connection is closed
+	// with CloseInvalidPayload.
+	ErrInvalidPayload = errors.New("websocket: invalid payload")
+	// ErrCloseSent is returned for writes after a close frame was sent.
+	ErrCloseSent = errors.New("websocket: close sent")
+)
+
+// CloseError is returned by ReadMessage once the peer has closed the
+// connection, with the code and reason it gave.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with code %d: %s", e.Code, e.Reason)
+}
+
+// deflateTail is the end of the empty stored block a sync flush produces,
+// which permessage-deflate strips from every message (RFC 7692 section
+// 7.2.1).
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+var flateWriters sync.Pool
+
+// Conn is a WebSocket connection. One goroutine may read messages while
+// another writes them; Ping, WriteClose and the automatic replies to pings
+// and closes may be sent concurrently with either.
+type Conn struct {
+	// Subprotocol is the subprotocol agreed in the handshake.
+	Subprotocol string
+
+	br          *bufio.Reader
+	rw          io.ReadWriter
+	client      bool
+	compression bool
+	readLimit   int64
+	readErr     error
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+// NewConn returns the server side of a WebSocket connection over rw, which
+// must carry frames once the response accepting hs has been sent. If rw is
+// an io.Closer, Close closes it.
+func NewConn(rw io.ReadWriter, hs Handshake, opts *Options) *Conn {
+	c := newConn(rw, false, hs.Compression)
+	c.Subprotocol = hs.Subprotocol
+	if opts != nil && opts.ReadLimit > 0 {
+		c.readLimit = opts.ReadLimit
+	}
+	return c
+}
+
+func newConn(rw io.ReadWriter, client, compression bool) *Conn {
+	return &Conn{
+		br:          bufio.NewReader(rw),
+		rw:          rw,
+		client:      client,
+		compression: compression,
+		readLimit:   defaultReadLimit,
+	}
+}
+
+// ReadMessage returns the next data message, reassembled from its
+// fragments and inflated if it was compressed. Pings are answered and pongs
+// skipped along the way. When the peer closes the connection, the close is
+// acknowledged and a *CloseError returned; after that, or any other error,
+// every call returns the same error.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	var (
+		typ        MessageType
+		msg        []byte
+		compressed bool
+	)
+	for {
+		f, err := c.readFrame(c.readLimit - int64(len(msg)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch f.op {
+		case opPing:
+			if err := c.writeFrame(opPong, false, true, f.payload); err != nil && err != ErrCloseSent {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.fail(c.closeReceived(f.payload))
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(fmt.Errorf("%w: new message before the last one finished", ErrProtocol))
+			}
+			typ, compressed = MessageType(f.op), f.rsv1
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(fmt.Errorf("%w: continuation without a message", ErrProtocol))
+			}
+			if f.rsv1 {
+				return 0, nil, c.fail(fmt.Errorf("%w: RSV1 set on a continuation frame", ErrProtocol))
+			}
+		}
+
+		msg = append(msg, f.payload...)
+		if f.fin {
+			break
+		}
+	}
+
+	if compressed {
+		var err error
+		if msg, err = c.inflate(msg); err != nil {
+			return 0, nil, c.fail(err)
+		}
+	}
+	if typ == TextMessage && !utf8.Valid(msg) {
+		return 0, nil, c.fail(fmt.Errorf("%w: text message is not UTF-8", ErrInvalidPayload))
+	}
+	return typ, msg, nil
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	op      byte
+	payload []byte
+}
+
+// readFrame reads one frame, whose payload may be at most limit bytes if it
+// is a data frame.
+func (c *Conn) readFrame(limit int64) (frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: head[0]&finBit != 0, rsv1: head[0]&rsv1Bit != 0, op: head[0] & 0x0f}
+	control := f.op&0x8 != 0
+
+	switch {
+	case head[0]&(rsv2Bit|rsv3Bit) != 0:
+		return frame{}, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	case f.rsv1 && (!c.compression || control):
+		return frame{}, fmt.Errorf("%w: RSV1 set without compression", ErrProtocol)
+	case f.op > opBinary && f.op < opClose, f.op > opPong:
+		return frame{}, fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, f.op)
+	case control && !f.fin:
+		return frame{}, fmt.Errorf("%w: fragmented control frame", ErrProtocol)
+	}
+
+	masked := head[1]&maskBit != 0
+	if masked == c.client {
+		if c.client {
+			return frame{}, fmt.Errorf("%w: masked frame from server", ErrProtocol)
+		}
+		return frame{}, fmt.Errorf("%w: unmasked frame from client", ErrProtocol)
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame{}, unexpectedEOF(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame{}, unexpectedEOF(err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return frame{}, fmt.Errorf("%w: invalid payload length", ErrProtocol)
+		}
+	}
+	if control && length > maxControlPayload {
+		return frame{}, fmt.Errorf("%w: control frame of %d bytes", ErrProtocol, length)
+	}
+	if !control && length > uint64(max(limit, 0)) {
+		return frame{}, fmt.Errorf("%w: limit is %d bytes", ErrMessageTooBig, c.readLimit)
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return frame{}, unexpectedEOF(err)
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return frame{}, unexpectedEOF(err)
+	}
+	if masked {
+		mask(key, f.payload)
+	}
+	return f, nil
+}
+
+// closeReceived handles the peer's close frame: it echoes the code back,
+// unless a close was already sent, and returns the CloseError reads report
+// from now on.
+func (c *Conn) closeReceived(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return fmt.Errorf("%w: close frame of 1 byte", ErrProtocol)
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return fmt.Errorf("%w: invalid close code %d", ErrProtocol, closeErr.Code)
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return fmt.Errorf("%w: close reason is not UTF-8", ErrInvalidPayload)
+		}
+	}
+
+	var echo []byte
+	if closeErr.Code != CloseNoStatus {
+		echo = payload[:2]
+	}
+	if err := c.writeFrame(opClose, false, true, echo); err != nil && err != ErrCloseSent {
+		return err
+	}
+	return closeErr
+}
+
+// fail makes err the result of every later read. Protocol violations are
+// answered with a close frame carrying the matching code first.
+func (c *Conn) fail(err error) error {
+	code := 0
+	switch {
+	case errors.Is(err, ErrProtocol):
+		code = CloseProtocolError
+	case errors.Is(err, ErrMessageTooBig):
+		code = CloseMessageTooBig
+	case errors.Is(err, ErrInvalidPayload):
+		code = CloseInvalidPayload
+	}
+	if code != 0 {
+		c.WriteClose(code, "")
+	}
+	c.readErr = err
+	return err
+}
+
+func (c *Conn) inflate(p []byte) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail)))
+	defer fr.Close()
+
+	var out bytes.Buffer
+	n, err := out.ReadFrom(io.LimitReader(fr, c.readLimit+1))
+	// The stream ends after the restored sync flush, without a final
+	// block, so running out of input there is its normal end.
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if n > c.readLimit {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrMessageTooBig, c.readLimit)
+	}
+	return out.Bytes(), nil
+}
+
+// WriteMessage sends data as one message of type typ.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	w, err := c.NextWriter(typ)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// NextWriter returns a writer for a message of type typ, sent in frames of
+// up to 4 KiB as it is written and finished by Close. Only one message may
+// be written at a time.
+func (c *Conn) NextWriter(typ MessageType) (io.WriteCloser, error) {
+	if typ != TextMessage && typ != BinaryMessage {
+		return nil, fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	mw := &messageWriter{c: c, op: byte(typ)}
+	if c.compression {
+		mw.compressed = true
+		fw, _ := flateWriters.Get().(*flate.Writer)
+		if fw == nil {
+			fw, _ = flate.NewWriter(framer{mw}, flate.DefaultCompression)
+		} else {
+			fw.Reset(framer{mw})
+		}
+		mw.fw = fw
+	}
+	return mw, nil
+}
+
+// Ping sends a ping, which the peer answers with a pong carrying the same
+// data.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: ping of %d bytes, limit is %d", len(data), maxControlPayload)
+	}
+	return c.writeFrame(opPing, false, true, data)
+}
+
+// WriteClose starts the closing handshake with code and reason, after which
+// nothing more can be written. The peer answers with its own close, which
+// ReadMessage reports as a *CloseError; the caller then closes the
+// connection.
+func (c *Conn) WriteClose(code int, reason string) error {
+	if !validCloseCode(code) {
+		return fmt.Errorf("websocket: invalid close code %d", code)
+	}
+	if len(reason) > maxControlPayload-2 {
+		return fmt.Errorf("websocket: close reason of %d bytes, limit is %d", len(reason), maxControlPayload-2)
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.writeFrame(opClose, false, true, append(payload, reason...))
+}
+
+// Close closes the underlying connection without a closing handshake. Call
+// WriteClose first, and wait for the peer's close, to end it cleanly.
+func (c *Conn) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// writeFrame sends one frame. Client frames are masked with a fresh key.
+func (c *Conn) writeFrame(op byte, rsv1, fin bool, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	b := make([]byte, 0, 14+len(payload))
+	first := op
+	if fin {
+		first |= finBit
+	}
+	if rsv1 {
+		first |= rsv1Bit
+	}
+	b = append(b, first)
+
+	var maskFlag byte
+	if c.client {
+		maskFlag = maskBit
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		b = append(b, maskFlag|byte(n))
+	case n <= 0xffff:
+		b = append(b, maskFlag|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, maskFlag|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+
+	if c.client {
+		var key [4]byte
+		rand.Read(key[:])
+		b = append(b, key[:]...)
+		start := len(b)
+		b = append(b, payload...)
+		mask(key, b[start:])
+	} else {
+		b = append(b, payload...)
+	}
+
+	if op == opClose {
+		c.closeSent = true
+	}
+	_, err := c.rw.Write(b)
+	return err
+}
+
+// messageWriter fragments a message into frames as it is written. With
+// compression, the deflate stream goes through framer into buf, which
+// always holds back the last four bytes: the stream ends in the sync flush
+// tail that must be stripped from the final frame.
+type messageWriter struct {
+	c          *Conn
+	op         byte
+	buf        []byte
+	compressed bool
+	fw         *flate.Writer
+	closed     bool
+}
+
+func (mw *messageWriter) Write(p []byte) (int, error) {
+	if mw.closed {
+		return 0, ErrCloseSent
+	}
+	if mw.fw != nil {
+		return mw.fw.Write(p)
+	}
+	return mw.frame(p)
+}
+
+// frame buffers p and sends every full frame it completes.
+func (mw *messageWriter) frame(p []byte) (int, error) {
+	mw.buf = append(mw.buf, p...)
+	holdback := 0
+	if mw.compressed {
+		holdback = len(deflateTail)
+	}
+	for len(mw.buf)-holdback > writeFrameSize {
+		if err := mw.send(mw.buf[:writeFrameSize], false); err != nil {
+			return 0, err
+		}
+		mw.buf = mw.buf[writeFrameSize:]
+	}
+	return len(p), nil
+}
+
+func (mw *messageWriter) send(payload []byte, fin bool) error {
+	rsv1 := mw.compressed && mw.op != opContinuation
+	err := mw.c.writeFrame(mw.op, rsv1, fin, payload)
+	mw.op = opContinuation
+	return err
+}
+
+// Close sends the final frame.
+func (mw *messageWriter) Close() error {
+	if mw.closed {
+		return nil
+	}
+	mw.closed = true
+
+	if fw := mw.fw; fw != nil {
+		mw.fw = nil
+		err := fw.Flush()
+		flateWriters.Put(fw)
+		if err != nil {
+			return err
+		}
+		mw.buf = bytes.TrimSuffix(mw.buf, deflateTail)
+	}
+	return mw.send(mw.buf, true)
+}
+
+// framer feeds compressed output back into a messageWriter's frames.
+type framer struct {
+	mw *messageWriter
+}
+
+func (f framer) Write(p []byte) (int, error) {
+	return f.mw.frame(p)
+}
+
+func mask(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// validCloseCode reports whether code may appear in a close frame: one
+// defined by RFC 6455 or registered since, or one in the ranges for
+// libraries and applications.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,206 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+	"github.com/portbound/tcp-to-http/internal/server"
+)
+
+// acceptGUID is appended to the client's key to derive Sec-WebSocket-Accept
+// (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Options configures which handshakes Accept agrees to and the connections
+// they lead to.
+type Options struct {
+	// Subprotocols are the application protocols the server speaks, most
+	// preferred first. The first one the client also offers is chosen.
+	Subprotocols []string
+	// CheckOrigin decides whether a browser on another site may connect.
+	// When nil, a request whose Origin names a host other than its Host is
+	// refused, which stops other sites from using visitors' cookies to
+	// open connections.
+	CheckOrigin func(req *request.Request) bool
+	// Compression agrees to permessage-deflate (RFC 7692) when the client
+	// offers it.
+	Compression bool
+	// ReadLimit caps the size of a received message, after decompression.
+	// Zero means 1 MiB.
+	ReadLimit int64
+}
+
+// Handshake is what Accept agreed with the client.
+type Handshake struct {
+	// Subprotocol is the chosen subprotocol, or "" if there is none.
+	Subprotocol string
+	// Compression reports whether permessage-deflate is in use.
+	Compression bool
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept value answering key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Accept checks that req is a valid WebSocket opening handshake and sets
+// w's status and headers to the 101 Switching Protocols response accepting
+// it. The head still has to be sent, after which the connection speaks
+// WebSocket frames. A request that cannot be upgraded gets a HandlerError:
+// 405 for a method other than GET, 426 for a request that is not an upgrade
+// or asks for an unsupported version, 400 for a malformed key and 403 for a
+// refused origin.
+func Accept(w *response.Writer, req *request.Request, opts *Options) (Handshake, *server.HandlerError) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	h := req.Headers
+
+	if req.RequestLine.Method != "GET" {
+		w.Headers().Set("Allow", "GET")
+		return Handshake{}, &server.HandlerError{StatusCode: response.StatusMethodNotAllowed, Message: "websocket handshake must be GET"}
+	}
+	if !hasToken(h.Get("Upgrade"), "websocket") || !hasToken(h.Get("Connection"), "upgrade") {
+		w.Headers().Set("Upgrade", "websocket")
+		w.Headers().Set("Connection", "Upgrade")
+		return Handshake{}, &server.HandlerError{StatusCode: response.StatusUpgradeRequired, Message: "expected a websocket upgrade"}
+	}
+	if h.Get("Sec-WebSocket-Version") != "13" {
+		w.Headers().Set("Sec-WebSocket-Version", "13")
+		return Handshake{}, &server.HandlerError{StatusCode: response.StatusUpgradeRequired, Message: "unsupported websocket version"}
+	}
+	key := h.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		return Handshake{}, &server.HandlerError{StatusCode: response.StatusBadRequest, Message: fmt.Sprintf("invalid Sec-WebSocket-Key %q", key)}
+	}
+
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return Handshake{}, &server.HandlerError{StatusCode: response.StatusForbidden, Message: fmt.Sprintf("origin %q not allowed", h.Get("Origin"))}
+	}
+
+	var hs Handshake
+	rh := w.Headers()
+	rh.Del("Content-Type")
+	rh.Set("Upgrade", "websocket")
+	rh.Set("Connection", "Upgrade")
+	rh.Set("Sec-WebSocket-Accept", AcceptKey(key))
+
+	if hs.Subprotocol = chooseSubprotocol(h.Get("Sec-WebSocket-Protocol"), opts.Subprotocols); hs.Subprotocol != "" {
+		rh.Set("Sec-WebSocket-Protocol", hs.Subprotocol)
+	}
+	if opts.Compression && acceptsDeflate(h.Get("Sec-WebSocket-Extensions")) {
+		// Neither side keeps a compression context between messages, so
+		// every message can be inflated on its own.
+		rh.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+		hs.Compression = true
+	}
+
+	w.SetStatusCode(response.StatusSwitchingProtocols)
+	return hs, nil
+}
+
+// Upgrade accepts the handshake in req, sends the 101 response and takes
+// over the connection, returning it as a Conn. Errors before the response
+// is sent come back as a HandlerError for the handler to return; once the
+// connection is taken over, the server leaves it to the Conn, which must be
+// closed when done.
+func Upgrade(w *response.Writer, req *request.Request, opts *Options) (*Conn, *server.HandlerError) {
+	hs, handlerErr := Accept(w, req, opts)
+	if handlerErr != nil {
+		return nil, handlerErr
+	}
+	if err := w.Flush(); err != nil {
+		return nil, &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+	return NewConn(hijackedConn{conn, io.MultiReader(bytes.NewReader(buffered), conn)}, hs, opts), nil
+}
+
+// hijackedConn reads frames the client sent along with its handshake
+// before reading from the connection itself.
+type hijackedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c hijackedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// sameOrigin accepts requests without an Origin, which do not come from
+// browsers, and ones whose Origin host matches Host.
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Headers.Get("Host"))
+}
+
+// hasToken reports whether the comma-separated list value contains token.
+func hasToken(value, token string) bool {
+	for elem := range strings.SplitSeq(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(elem), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func chooseSubprotocol(offered string, supported []string) string {
+	for _, proto := range supported {
+		if hasToken(offered, proto) {
+			return proto
+		}
+	}
+	return ""
+}
+
+// acceptsDeflate reports whether the client offers a permessage-deflate
+// configuration this package can honour. compress/flate always uses a full
+// 32 KiB window, so an offer limiting the server's window is declined.
+func acceptsDeflate(extensions string) bool {
+	for offer := range strings.SplitSeq(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "permessage-deflate") {
+			continue
+		}
+		ok := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				if strings.Trim(strings.TrimSpace(value), `"`) != "15" {
+					ok = false
+				}
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
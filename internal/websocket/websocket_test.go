@@ -0,0 +1,498 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/portbound/tcp-to-http/internal/headers"
+	"github.com/portbound/tcp-to-http/internal/request"
+	"github.com/portbound/tcp-to-http/internal/response"
+	"github.com/portbound/tcp-to-http/internal/server"
+)
+
+func TestAccept(t *testing.T) {
+	valid := map[string]string{
+		"host":                  "example.com",
+		"upgrade":               "websocket",
+		"connection":            "keep-alive, Upgrade",
+		"sec-websocket-key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		"sec-websocket-version": "13",
+	}
+	with := func(changes map[string]string) headers.Headers {
+		h := headers.Headers{}
+		for k, v := range valid {
+			h[k] = v
+		}
+		for k, v := range changes {
+			if v == "" {
+				delete(h, k)
+			} else {
+				h[k] = v
+			}
+		}
+		return h
+	}
+
+	tests := []struct {
+		name              string
+		method            string
+		headers           headers.Headers
+		opts              *Options
+		expectStatus      response.StatusCode
+		expectProtocol    string
+		expectCompression bool
+	}{
+		{name: "Valid", headers: with(nil)},
+		{name: "Same origin", headers: with(map[string]string{"origin": "https://example.com"})},
+		{name: "Cross origin", headers: with(map[string]string{"origin": "https://evil.example"}), expectStatus: response.StatusForbidden},
+		{
+			name:    "Cross origin allowed",
+			headers: with(map[string]string{"origin": "https://evil.example"}),
+			opts:    &Options{CheckOrigin: func(*request.Request) bool { return true }},
+		},
+		{name: "POST", method: "POST", headers: with(nil), expectStatus: response.StatusMethodNotAllowed},
+		{name: "Not an upgrade", headers: with(map[string]string{"upgrade": ""}), expectStatus: response.StatusUpgradeRequired},
+		{name: "Connection without upgrade", headers: with(map[string]string{"connection": "keep-alive"}), expectStatus: response.StatusUpgradeRequired},
+		{name: "Old version", headers: with(map[string]string{"sec-websocket-version": "8"}), expectStatus: response.StatusUpgradeRequired},
+		{name: "Missing key", headers: with(map[string]string{"sec-websocket-key": ""}), expectStatus: response.StatusBadRequest},
+		{name: "Short key", headers: with(map[string]string{"sec-websocket-key": "c2hvcnQ="}), expectStatus: response.StatusBadRequest},
+		{
+			name:           "Subprotocol by server preference",
+			headers:        with(map[string]string{"sec-websocket-protocol": "chat.v1, chat.v2"}),
+			opts:           &Options{Subprotocols: []string{"chat.v2", "chat.v1"}},
+			expectProtocol: "chat.v2",
+		},
+		{
+			name:    "No common subprotocol",
+			headers: with(map[string]string{"sec-websocket-protocol": "mqtt"}),
+			opts:    &Options{Subprotocols: []string{"chat.v1"}},
+		},
+		{
+			name:              "Compression",
+			headers:           with(map[string]string{"sec-websocket-extensions": "permessage-deflate; client_max_window_bits"}),
+			opts:              &Options{Compression: true},
+			expectCompression: true,
+		},
+		{
+			name:    "Compression not enabled",
+			headers: with(map[string]string{"sec-websocket-extensions": "permessage-deflate"}),
+		},
+		{
+			name:    "Compression with a small server window",
+			headers: with(map[string]string{"sec-websocket-extensions": "permessage-deflate; server_max_window_bits=10"}),
+			opts:    &Options{Compression: true},
+		},
+		{
+			name:              "Second offer acceptable",
+			headers:           with(map[string]string{"sec-websocket-extensions": "permessage-deflate; server_max_window_bits=10, permessage-deflate"}),
+			opts:              &Options{Compression: true},
+			expectCompression: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := &request.Request{
+				RequestLine: request.RequestLine{Method: method, RequestTarget: "/ws", HttpVersion: "HTTP/1.1"},
+				Headers:     tc.headers,
+			}
+			w := response.NewWriter(&bytes.Buffer{})
+
+			hs, handlerErr := Accept(w, req, tc.opts)
+			if tc.expectStatus != 0 {
+				if handlerErr == nil || handlerErr.StatusCode != tc.expectStatus {
+					t.Fatalf("got error %v, want status %d", handlerErr, tc.expectStatus)
+				}
+				return
+			}
+			if handlerErr != nil {
+				t.Fatalf("unexpected error: %v", handlerErr.Message)
+			}
+
+			h := w.Headers()
+			if w.StatusCode() != response.StatusSwitchingProtocols {
+				t.Errorf("got status %d, want %d", w.StatusCode(), response.StatusSwitchingProtocols)
+			}
+			// The example handshake from RFC 6455 section 1.3.
+			if got := h.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+				t.Errorf("got Sec-WebSocket-Accept %q", got)
+			}
+			if h.Get("Upgrade") != "websocket" || h.Get("Connection") != "Upgrade" {
+				t.Errorf("got Upgrade %q and Connection %q", h.Get("Upgrade"), h.Get("Connection"))
+			}
+			if hs.Subprotocol != tc.expectProtocol || h.Get("Sec-WebSocket-Protocol") != tc.expectProtocol {
+				t.Errorf("got subprotocol %q and header %q, want %q", hs.Subprotocol, h.Get("Sec-WebSocket-Protocol"), tc.expectProtocol)
+			}
+			if hs.Compression != tc.expectCompression || (h.Get("Sec-WebSocket-Extensions") != "") != tc.expectCompression {
+				t.Errorf("got compression %v and extensions %q, want %v", hs.Compression, h.Get("Sec-WebSocket-Extensions"), tc.expectCompression)
+			}
+		})
+	}
+}
+
+type duplex struct {
+	io.Reader
+	io.Writer
+}
+
+// pair returns the two ends of a connection over in-memory buffers. Frames
+// written by one end can be read by the other once written; nothing blocks.
+func pair(compression bool) (client, server *Conn) {
+	toServer, toClient := &bytes.Buffer{}, &bytes.Buffer{}
+	client = newConn(duplex{toClient, toServer}, true, compression)
+	server = newConn(duplex{toServer, toClient}, false, compression)
+	return client, server
+}
+
+func TestMessages(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression bool
+		typ         MessageType
+		data        string
+	}{
+		{name: "Text", typ: TextMessage, data: "hello, 世界"},
+		{name: "Empty", typ: TextMessage, data: ""},
+		{name: "Binary", typ: BinaryMessage, data: "\x00\x01\xff"},
+		{name: "Medium length", typ: BinaryMessage, data: strings.Repeat("m", 300)},
+		{name: "Fragmented", typ: TextMessage, data: strings.Repeat("build log line\n", 1000)},
+		{name: "Compressed", compression: true, typ: TextMessage, data: strings.Repeat("build log line\n", 100)},
+		{name: "Compressed empty", compression: true, typ: BinaryMessage, data: ""},
+		{name: "Compressed fragmented", compression: true, typ: BinaryMessage, data: incompressible(20000)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := pair(tc.compression)
+
+			if err := client.WriteMessage(tc.typ, []byte(tc.data)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			typ, data, err := server.ReadMessage()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if typ != tc.typ || string(data) != tc.data {
+				t.Errorf("server got type %d and %.40q, want type %d and %.40q", typ, data, tc.typ, tc.data)
+			}
+
+			if err := server.WriteMessage(tc.typ, data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			typ, data, err = client.ReadMessage()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if typ != tc.typ || string(data) != tc.data {
+				t.Errorf("client got type %d and %.40q, want type %d and %.40q", typ, data, tc.typ, tc.data)
+			}
+		})
+	}
+}
+
+// incompressible returns n bytes that deflate cannot shrink, so compressed
+// messages still span several frames.
+func incompressible(n int) string {
+	b := make([]byte, n)
+	x := uint32(2463534242)
+	for i := range b {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		b[i] = byte(x)
+	}
+	return string(b)
+}
+
+func TestControlFrames(t *testing.T) {
+	client, server := pair(false)
+
+	// A ping between the fragments of a message is answered at once.
+	client.writeFrame(opText, false, false, []byte("Hel"))
+	client.Ping([]byte("are you there"))
+	client.writeFrame(opPong, false, true, []byte("unsolicited"))
+	client.writeFrame(opContinuation, false, true, []byte("lo"))
+
+	typ, data, err := server.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if typ != TextMessage || string(data) != "Hello" {
+		t.Errorf("got type %d and %q, want the reassembled text", typ, data)
+	}
+
+	f, err := client.readFrame(defaultReadLimit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.op != opPong || string(f.payload) != "are you there" {
+		t.Errorf("got opcode %#x and %q, want a pong echoing the ping", f.op, f.payload)
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	client, server := pair(false)
+
+	if err := client.WriteClose(CloseGoingAway, "shutting down"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := client.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, ErrCloseSent) {
+		t.Errorf("got error %v writing after close, want %v", err, ErrCloseSent)
+	}
+
+	_, _, err := server.ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Reason != "shutting down" {
+		t.Fatalf("got error %v, want close %d", err, CloseGoingAway)
+	}
+	if _, _, again := server.ReadMessage(); again != err {
+		t.Errorf("got %v reading again, want the same close error", again)
+	}
+
+	_, _, err = client.ReadMessage()
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway {
+		t.Fatalf("got error %v, want the close echoed", err)
+	}
+	if err := server.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, ErrCloseSent) {
+		t.Errorf("got error %v writing after close, want %v", err, ErrCloseSent)
+	}
+
+	if err := client.WriteClose(CloseNoStatus, ""); err == nil {
+		t.Error("expected an error sending a reserved close code")
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	closePayload := func(code int) []byte {
+		return binary.BigEndian.AppendUint16(nil, uint16(code))
+	}
+
+	tests := []struct {
+		name        string
+		readLimit   int64
+		compression bool
+		send        func(client, unmasked *Conn)
+		expectErr   error
+		expectCode  int
+	}{
+		{
+			name:       "Unmasked frame",
+			send:       func(client, unmasked *Conn) { unmasked.writeFrame(opText, false, true, []byte("x")) },
+			expectErr:  ErrProtocol,
+			expectCode: CloseProtocolError,
+		},
+		{
+			name:       "Continuation without a message",
+			send:       func(client, unmasked *Conn) { client.writeFrame(opContinuation, false, true, []byte("x")) },
+			expectErr:  ErrProtocol,
+			expectCode: CloseProtocolError,
+		},
+		{
+			name: "Message inside a message",
+			send: func(client, unmasked *Conn) {
+				client.writeFrame(opText, false, false, []byte("a"))
+				client.writeFrame(opText, false, true, []byte("b"))
+			},
+			expectErr:  ErrProtocol,
+			expectCode: CloseProtocolError,
+		},
+		{
+			name:       "Fragmented ping",
+			send:       func(client, unmasked *Conn) { client.writeFrame(opPing, false, false, nil) },
+			expectErr:  ErrProtocol,
+			expectCode: CloseProtocolError,
+		},
+		{
+			name:       "Oversized ping",
+			send:       func(client, unmasked *Conn) { client.writeFrame(opPing, false, true, make([]byte, 126)) },
+			expectErr:  ErrProtocol,
+			expectCode: CloseProtocolError,
+		},
+		{
+			name:       "Unknown opcode",
+			send:       func(client, unmasked *Conn) { client.writeFrame(0x3, false, true, nil) },
+			expectErr:  ErrProtocol,
+			expectCode: CloseProtocolError,
+		},
+		{
+			name:       "RSV1 without compression",
+			send:       func(client, unmasked *Conn) { client.writeFrame(opText, true, true, []byte("x")) },
+			expectErr:  ErrProtocol,
+			expectCode: CloseProtocolError,
+		},
+		{
+			name:       "Invalid close code",
+			send:       func(client, unmasked *Conn) { client.writeFrame(opClose, false, true, closePayload(999)) },
+			expectErr:  ErrProtocol,
+			expectCode: CloseProtocolError,
+		},
+		{
+			name:       "Invalid UTF-8",
+			send:       func(client, unmasked *Conn) { client.writeFrame(opText, false, true, []byte{'a', 0xff}) },
+			expectErr:  ErrInvalidPayload,
+			expectCode: CloseInvalidPayload,
+		},
+		{
+			name:        "Corrupt compressed data",
+			compression: true,
+			send:        func(client, unmasked *Conn) { client.writeFrame(opBinary, true, true, []byte{0xff, 0xff, 0xff}) },
+			expectErr:   ErrInvalidPayload,
+			expectCode:  CloseInvalidPayload,
+		},
+		{
+			name:       "Too big",
+			readLimit:  10,
+			send:       func(client, unmasked *Conn) { client.WriteMessage(BinaryMessage, make([]byte, 11)) },
+			expectErr:  ErrMessageTooBig,
+			expectCode: CloseMessageTooBig,
+		},
+		{
+			name:      "Too big across fragments",
+			readLimit: 10,
+			send: func(client, unmasked *Conn) {
+				client.writeFrame(opBinary, false, false, make([]byte, 6))
+				client.writeFrame(opContinuation, false, true, make([]byte, 6))
+			},
+			expectErr:  ErrMessageTooBig,
+			expectCode: CloseMessageTooBig,
+		},
+		{
+			name:        "Too big once inflated",
+			readLimit:   100,
+			compression: true,
+			send:        func(client, unmasked *Conn) { client.WriteMessage(TextMessage, bytes.Repeat([]byte("a"), 1000)) },
+			expectErr:   ErrMessageTooBig,
+			expectCode:  CloseMessageTooBig,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := pair(tc.compression)
+			if tc.readLimit > 0 {
+				server.readLimit = tc.readLimit
+			}
+			unmasked := newConn(client.rw, false, false)
+			tc.send(client, unmasked)
+
+			if _, _, err := server.ReadMessage(); !errors.Is(err, tc.expectErr) {
+				t.Fatalf("got error %v, want %v", err, tc.expectErr)
+			}
+			f, err := client.readFrame(defaultReadLimit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if f.op != opClose || len(f.payload) < 2 || int(binary.BigEndian.Uint16(f.payload)) != tc.expectCode {
+				t.Errorf("got opcode %#x and payload %q, want close %d", f.op, f.payload, tc.expectCode)
+			}
+		})
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		conn, handlerErr := Upgrade(w, req, nil)
+		if handlerErr != nil {
+			return handlerErr
+		}
+		defer conn.Close()
+		for {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				return nil
+			}
+			conn.WriteMessage(typ, data)
+		}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	t.Run("Echo", func(t *testing.T) {
+		nc, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer nc.Close()
+
+		// The first message goes out with the handshake, so the server has
+		// to pick it up from the bytes read along with the request.
+		out := &bytes.Buffer{}
+		out.WriteString("GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+		newConn(duplex{nil, out}, true, false).WriteMessage(TextMessage, []byte("early"))
+		if _, err := nc.Write(out.Bytes()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		br := bufio.NewReader(nc)
+		var head strings.Builder
+		for !strings.HasSuffix(head.String(), "\r\n\r\n") {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("unexpected error: %v in %q", err, head.String())
+			}
+			head.WriteString(line)
+		}
+		if !strings.HasPrefix(head.String(), "HTTP/1.1 101 Switching Protocols\r\n") ||
+			!strings.Contains(head.String(), "Sec-Websocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n") {
+			t.Fatalf("got head %q", head.String())
+		}
+
+		client := newConn(duplex{br, nc}, true, false)
+		for _, want := range []string{"early", "late"} {
+			if want == "late" {
+				client.WriteMessage(TextMessage, []byte(want))
+			}
+			_, data, err := client.ReadMessage()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(data) != want {
+				t.Errorf("got %q, want %q", data, want)
+			}
+		}
+
+		client.WriteClose(CloseNormal, "")
+		var closeErr *CloseError
+		if _, _, err := client.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != CloseNormal {
+			t.Errorf("got error %v, want close %d", err, CloseNormal)
+		}
+	})
+
+	t.Run("Not an upgrade", func(t *testing.T) {
+		nc, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer nc.Close()
+		fmt.Fprint(nc, "GET /ws HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+		raw, err := io.ReadAll(nc)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp, err := response.ResponseFromReader(bytes.NewReader(raw), "GET")
+		if err != nil {
+			t.Fatalf("unexpected error: %v in %q", err, raw)
+		}
+		if resp.StatusLine.StatusCode != response.StatusUpgradeRequired {
+			t.Errorf("got status %d, want %d", resp.StatusLine.StatusCode, response.StatusUpgradeRequired)
+		}
+		if got := resp.Headers.Get("Upgrade"); got != "websocket" {
+			t.Errorf("got Upgrade %q, want %q", got, "websocket")
+		}
+	})
+}